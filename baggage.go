@@ -0,0 +1,173 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+const (
+	baggagePrefix = "baggage-"
+
+	defaultBaggageMaxItems     = 64
+	defaultBaggageMaxItemSize  = 1024
+	defaultBaggageMaxTotalSize = 8192
+)
+
+// defaultBaggagePolicy limits the baggage, such as incoming uberctx-* items,
+// carried when baggage is not configured.
+var defaultBaggagePolicy = newBaggagePolicy(&BaggageConfig{})
+
+// baggagePolicy restricts the baggage carried across calls.
+type baggagePolicy struct {
+	allowed      map[string]bool
+	tagKeys      []string
+	maxItems     int
+	maxItemSize  int
+	maxTotalSize int
+}
+
+func newBaggagePolicy(c *BaggageConfig) *baggagePolicy {
+	p := &baggagePolicy{
+		maxItems:     c.MaxItems,
+		maxItemSize:  c.MaxItemSize,
+		maxTotalSize: c.MaxTotalSize,
+	}
+	for _, k := range c.TagKeys {
+		p.tagKeys = append(p.tagKeys, strings.ToLower(k))
+	}
+	if len(c.AllowedKeys) > 0 {
+		p.allowed = make(map[string]bool, len(c.AllowedKeys))
+		for _, k := range c.AllowedKeys {
+			p.allowed[strings.ToLower(k)] = true
+		}
+	}
+	if p.maxItems <= 0 {
+		p.maxItems = defaultBaggageMaxItems
+	}
+	if p.maxItemSize <= 0 {
+		p.maxItemSize = defaultBaggageMaxItemSize
+	}
+	if p.maxTotalSize <= 0 {
+		p.maxTotalSize = defaultBaggageMaxTotalSize
+	}
+	return p
+}
+
+// restrict drops the baggage items of tc which are not allowed or exceed the size
+// limits. Items are kept in key order until the item count or total size limit is
+// reached. A nil policy applies the default limits.
+func (p *baggagePolicy) restrict(tc *traceContext) {
+	if p == nil {
+		p = defaultBaggagePolicy
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	keys := make([]string, 0, len(tc.baggage))
+	for k := range tc.baggage {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var total, items int
+	for _, k := range keys {
+		size := len(k) + len(tc.baggage[k])
+		switch {
+		case p.allowed != nil && !p.allowed[k]:
+		case items >= p.maxItems:
+			log.Debugf("trpc-opentracing-zipkin: baggage item %s dropped, items exceed %d", k, p.maxItems)
+		case size > p.maxItemSize:
+			log.Debugf("trpc-opentracing-zipkin: baggage item %s dropped, size %d exceeds %d", k, size, p.maxItemSize)
+		case total+size > p.maxTotalSize:
+			log.Debugf("trpc-opentracing-zipkin: baggage item %s dropped, total size exceeds %d", k, p.maxTotalSize)
+		default:
+			total += size
+			items++
+			continue
+		}
+		delete(tc.baggage, k)
+	}
+}
+
+// tag promotes the configured baggage items of tc into tags of span.
+func (p *baggagePolicy) tag(span opentracing.Span, tc *traceContext) {
+	if p == nil {
+		return
+	}
+	for _, k := range p.tagKeys {
+		if v := tc.baggageItem(k); v != "" {
+			span.SetTag(k, v)
+		}
+	}
+}
+
+// baggagePropagator carries baggage items as baggage-{key} in trpc metadata and http headers.
+type baggagePropagator struct{}
+
+func (baggagePropagator) owns(key string) bool {
+	return strings.HasPrefix(key, baggagePrefix)
+}
+
+func (baggagePropagator) inject(tc *traceContext, carrier textMap) {
+	for k, v := range tc.baggage {
+		carrier.Set(baggagePrefix+k, v)
+	}
+}
+
+func (baggagePropagator) extract(textMap, *traceContext) (bool, error) {
+	return false, nil
+}
+
+func (baggagePropagator) extractBaggage(carrier textMap, tc *traceContext) {
+	_ = carrier.ForeachKey(func(key, val string) error {
+		if k := strings.ToLower(key); strings.HasPrefix(k, baggagePrefix) && len(k) > len(baggagePrefix) {
+			tc.setBaggageItem(k[len(baggagePrefix):], val)
+		}
+		return nil
+	})
+}
+
+// baggageSpan carries the baggage of the server call, since spans of
+// zipkin-go-opentracing do not support baggage. Items set on it are
+// propagated to the downstream calls made with its context.
+type baggageSpan struct {
+	opentracing.Span
+	tc *traceContext
+}
+
+// SetOperationName implements opentracing.Span.
+func (s *baggageSpan) SetOperationName(operationName string) opentracing.Span {
+	s.Span.SetOperationName(operationName)
+	return s
+}
+
+// SetTag implements opentracing.Span.
+func (s *baggageSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.Span.SetTag(key, value)
+	return s
+}
+
+// SetBaggageItem implements opentracing.Span.
+func (s *baggageSpan) SetBaggageItem(key, val string) opentracing.Span {
+	s.tc.setBaggageItem(strings.ToLower(key), val)
+	return s
+}
+
+// BaggageItem implements opentracing.Span.
+func (s *baggageSpan) BaggageItem(key string) string {
+	return s.tc.baggageItem(strings.ToLower(key))
+}
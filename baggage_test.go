@@ -0,0 +1,153 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+func Test_baggagePolicy_restrict(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *BaggageConfig
+		in   map[string]string
+		want map[string]string
+	}{
+		{
+			name: "all allowed",
+			cfg:  &BaggageConfig{},
+			in:   map[string]string{"user": "1", "tenant": "2"},
+			want: map[string]string{"user": "1", "tenant": "2"},
+		},
+		{
+			name: "allowlist",
+			cfg:  &BaggageConfig{AllowedKeys: []string{"User"}},
+			in:   map[string]string{"user": "1", "tenant": "2"},
+			want: map[string]string{"user": "1"},
+		},
+		{
+			name: "item size",
+			cfg:  &BaggageConfig{MaxItemSize: 5},
+			in:   map[string]string{"user": "1", "tenant": "2"},
+			want: map[string]string{"user": "1"},
+		},
+		{
+			name: "items",
+			cfg:  &BaggageConfig{MaxItems: 2},
+			in:   map[string]string{"a": "1", "b": "2", "c": "3"},
+			want: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name: "total size",
+			cfg:  &BaggageConfig{MaxTotalSize: 12},
+			in:   map[string]string{"a": "1", "b": strings.Repeat("x", 10), "c": "3"},
+			want: map[string]string{"a": "1", "c": "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &traceContext{baggage: tt.in}
+			newBaggagePolicy(tt.cfg).restrict(tc)
+			assert.Equal(t, tt.want, tc.baggage)
+		})
+	}
+
+	// a nil policy applies the default limits
+	tc := &traceContext{baggage: map[string]string{"user": "1"}}
+	(*baggagePolicy)(nil).restrict(tc)
+	assert.Equal(t, map[string]string{"user": "1"}, tc.baggage)
+	tc = &traceContext{baggage: map[string]string{"big": strings.Repeat("x", defaultBaggageMaxItemSize)}}
+	for i := 0; i < 2*defaultBaggageMaxItems; i++ {
+		tc.baggage[fmt.Sprintf("k%03d", i)] = "v"
+	}
+	(*baggagePolicy)(nil).restrict(tc)
+	assert.Len(t, tc.baggage, defaultBaggageMaxItems)
+	assert.NotContains(t, tc.baggage, "big")
+}
+
+func Test_baggagePropagator(t *testing.T) {
+	ps := propagators{b3Propagator{}, baggagePropagator{}}
+	md := metadataTextMap{}
+	ps.inject(&traceContext{
+		spanContext: newTestSpanContext(true),
+		baggage:     map[string]string{"user": "1"},
+	}, md)
+	assert.Equal(t, "1", md.Get("baggage-user"))
+
+	md["Baggage-Tenant"] = []byte("2")
+	tc, found, errs := ps.extract(md)
+	assert.True(t, found)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]string{"user": "1", "tenant": "2"}, tc.baggageItems())
+}
+
+func TestFilter_Baggage(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	z := &zipkinPlugin{
+		tracers:     map[string]opentracing.Tracer{"trpc.app.server.Service": tracer},
+		propagators: propagators{b3Propagator{}, baggagePropagator{}},
+		baggage: newBaggagePolicy(&BaggageConfig{
+			AllowedKeys: []string{"user", "tenant"},
+			TagKeys:     []string{"tenant"},
+		}),
+	}
+
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCalleeServiceName("trpc.app.server.Service")
+	msg.WithServerMetaData(codec.MetaData{
+		"baggage-user":   []byte("1"),
+		"baggage-secret": []byte("x"),
+	})
+	var clientMD codec.MetaData
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		span := opentracing.SpanFromContext(ctx)
+		assert.Equal(t, "1", span.BaggageItem("user"))
+		assert.Equal(t, "", span.BaggageItem("secret"))
+		span.SetTag("step", 1).SetBaggageItem("Tenant", "2")
+
+		clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+		clientMsg.WithCallerServiceName("trpc.app.server.Service")
+		err := ClientFilter(z)(clientCtx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+			clientMD = codec.Message(ctx).ClientMetaData()
+			return nil
+		})
+		return nil, err
+	}
+	_, err = ServerFilter(z)(ctx, nil, handler)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1", string(clientMD["baggage-user"]))
+	assert.Equal(t, "2", string(clientMD["baggage-tenant"]))
+	assert.Nil(t, clientMD["baggage-secret"])
+
+	spans := rec.Flush()
+	assert.Len(t, spans, 2)
+	for _, span := range spans {
+		if span.Kind == model.Client {
+			assert.Equal(t, "2", span.Tags["tenant"])
+		}
+	}
+}
@@ -14,7 +14,16 @@
 package zipkin
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -24,6 +33,8 @@ import (
 	"github.com/openzipkin/zipkin-go/reporter"
 	"github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/openzipkin/zipkin-go/reporter/kafka"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	trpc "trpc.group/trpc-go/trpc-go"
 )
 
@@ -34,10 +45,24 @@ const (
 	ModuloSampler   = "modulo"
 	BoundarySampler = "boundary"
 	CountingSampler = "counting"
+	// RateLimitingSampler samples a number of traces per second.
+	RateLimitingSampler = "ratelimiting"
+	// RemoteSampler samples with a strategy polled from a file or an http endpoint.
+	RemoteSampler = "remote"
+
+	defaultShutdownTimeout = 5 * time.Second
 
 	HTTPReporter  = "http"
 	KafkaReporter = "kafka"
 	NoopReporter  = "noop"
+	// GRPCReporter sends spans to the zipkin grpc collector.
+	GRPCReporter = "grpc"
+	// TRPCReporter sends spans to the http api of the collector through a trpc client.
+	TRPCReporter = "trpc"
+	// FileReporter writes spans as JSON lines to rotating files.
+	FileReporter = "file"
+	// LogReporter logs spans through a trpc logger.
+	LogReporter = "log"
 )
 
 // Config holds the configuration
@@ -47,82 +72,252 @@ type Config struct {
 	TraceID128  bool            `yaml:"trace_id_128"`
 	Sampler     *SamplerConfig  `yaml:"sampler"`
 	Reporter    *ReporterConfig `yaml:"reporter"`
+	// Propagation lists the formats of trace context across calls: b3, b3single, w3c and jaeger.
+	// All formats are injected, and the first one found is extracted. Defaults to b3.
+	Propagation []string `yaml:"propagation"`
+	// B3InjectStyle selects the headers injected by the b3 format: multi, single or both.
+	// Both forms are always accepted on extract. Defaults to multi.
+	B3InjectStyle string `yaml:"b3_inject_style"`
+	// Baggage enables baggage propagation over trpc metadata and http headers.
+	Baggage *BaggageConfig `yaml:"baggage"`
+	// ForceSample lets a request force the sampling of its trace with the debug flag.
+	ForceSample *ForceSampleConfig `yaml:"force_sample"`
+	// ErrorSampling reports the server and client spans of unsampled traces which end
+	// with an error, each as a root span tagged with the trace it belongs to.
+	ErrorSampling bool `yaml:"error_sampling"`
+	// ParentPolicy decides whether the sampling decision of the upstream is kept.
+	ParentPolicy *ParentPolicyConfig `yaml:"parent_policy"`
+	// TrustIncomingContext decides how the trace context of incoming requests is
+	// used: trust (default) ignore link
+	TrustIncomingContext string `yaml:"trust_incoming_context"`
+	// Tags are added to every span of the tracer.
+	Tags map[string]string `yaml:"tags"`
+	// ShutdownTimeoutSeconds bounds how long the reporter may take to flush
+	// pending spans when the server shuts down, defaults to 5 seconds.
+	ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds"`
+	// Services holds the overrides for trpc services, keyed by service name.
+	Services map[string]*ServiceConfig `yaml:"services"`
+	// Watch reloads the samplers and reporters when the trpc config file changes.
+	Watch bool `yaml:"watch"`
+}
+
+// ServiceConfig holds the configuration overrides for a trpc service,
+// unset fields fall back to the top-level config.
+type ServiceConfig struct {
+	// Enabled disables tracing of the service when set to false.
+	Enabled    *bool           `yaml:"enabled"`
+	TraceID128 *bool           `yaml:"trace_id_128"`
+	Sampler    *SamplerConfig  `yaml:"sampler"`
+	Reporter   *ReporterConfig `yaml:"reporter"`
+	// Tags are merged into the top-level tags.
+	Tags                 map[string]string   `yaml:"tags"`
+	ParentPolicy         *ParentPolicyConfig `yaml:"parent_policy"`
+	TrustIncomingContext string              `yaml:"trust_incoming_context"`
 }
 
 // NewOpenTracingTracer news a opentracing tracer
 func (c *Config) NewOpenTracingTracer() (opentracing.Tracer, error) {
-	zipkinTracer, err := c.NewZipkinTracer()
+	rep, err := c.newTrackedReporter()
 	if err != nil {
 		return nil, err
 	}
-
-	return zipkinOpentracing.Wrap(zipkinTracer), nil
+	tracer, err := c.newOpenTracingTracer(rep)
+	if err != nil {
+		_ = rep.Close()
+		return nil, err
+	}
+	return tracer, nil
 }
 
-// NewZipkinTracer news a zipkin tracer
+// NewZipkinTracer news a zipkin tracer. Samplers deciding by operation name
+// take all traces as one operation, since zipkin.Sampler is not given the name.
 func (c *Config) NewZipkinTracer() (*zipkin.Tracer, error) {
-	if err := c.checkConfig(); err != nil {
+	rep, err := c.newTrackedReporter()
+	if err != nil {
 		return nil, err
 	}
-	endpoint, err := zipkin.NewEndpoint(c.ServiceName, c.HostPort)
+	tracer, _, err := c.newZipkinTracer(rep)
 	if err != nil {
+		_ = rep.Close()
 		return nil, err
 	}
-	sampler, err := c.newZipkinSampler()
-	if err != nil {
+	return tracer, nil
+}
+
+// newTrackedReporter validates the config and news its reporter, which is
+// tracked so that it can be flushed and closed by Shutdown.
+func (c *Config) newTrackedReporter() (*sharedReporter, error) {
+	if err := c.Validate(); err != nil {
 		return nil, err
 	}
-	reporterConf := c.Reporter.reporterConfig()
-	if reporterConf == nil {
-		return nil, invalidConfigErr("reporter.type")
+	rep, err := c.newReporter()
+	if err != nil {
+		return nil, err
 	}
-	varReporter, err := reporterConf.newReporter()
+	return newSharedReporter(rep), nil
+}
+
+// newOpenTracingTracer news a opentracing tracer which reports through rep.
+func (c *Config) newOpenTracingTracer(rep reporter.Reporter) (opentracing.Tracer, error) {
+	zipkinTracer, opSampler, err := c.newZipkinTracer(rep)
 	if err != nil {
 		return nil, err
 	}
 
+	tracer := zipkinOpentracing.Wrap(zipkinTracer)
+	if opSampler != nil {
+		return &operationSamplingTracer{Tracer: tracer, sampler: opSampler, service: c.ServiceName}, nil
+	}
+	return tracer, nil
+}
+
+// newZipkinTracer news a zipkin tracer which reports through rep, and the
+// sampler which should decide new traces by operation name, if any.
+// The config must have been validated.
+func (c *Config) newZipkinTracer(rep reporter.Reporter) (*zipkin.Tracer, operationSampler, error) {
+	sampler, opSampler, err := c.newZipkinSampler()
+	if err != nil {
+		return nil, nil, err
+	}
+	if sr, ok := rep.(*sharedReporter); ok {
+		closeWithReporter(opSampler, sr)
+	}
+	tracer, err := c.newZipkinTracerWithSampler(rep, sampler)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracer, opSampler, nil
+}
+
+// newZipkinTracerWithSampler news a zipkin tracer which samples with sampler
+// and reports through rep.
+func (c *Config) newZipkinTracerWithSampler(rep reporter.Reporter, sampler zipkin.Sampler) (*zipkin.Tracer, error) {
+	endpoint, err := zipkin.NewEndpoint(c.ServiceName, c.HostPort)
+	if err != nil {
+		return nil, err
+	}
 	return zipkin.NewTracer(
-		varReporter,
+		remoteEndpointReporter{rep},
 		zipkin.WithLocalEndpoint(endpoint),
 		zipkin.WithSampler(sampler),
 		zipkin.WithTraceID128Bit(c.TraceID128),
+		zipkin.WithTags(c.Tags),
 	)
 }
 
-func (c *Config) checkConfig() error {
-	if c.Sampler == nil {
-		return invalidConfigErr("sampler")
+// closeWithReporter stops the samplers polling in background along with rep.
+func closeWithReporter(s operationSampler, rep *sharedReporter) {
+	if closer, ok := s.(io.Closer); ok {
+		rep.closeWith(closer)
 	}
-	if c.Reporter == nil {
-		return invalidConfigErr("reporter")
+}
+
+// newReporter news the reporter selected by the reporter config.
+// The config must have been validated.
+func (c *Config) newReporter() (reporter.Reporter, error) {
+	reporterConf := c.Reporter.reporterConfig()
+	if reporterConf == nil {
+		return nil, invalidConfigErr("reporter.type")
 	}
-	return nil
+	rep, err := reporterConf.newReporter()
+	if err != nil {
+		return nil, err
+	}
+	if c.Reporter.TailSampling != nil {
+		return newTailSamplingReporter(rep, c.Reporter.TailSampling), nil
+	}
+	return rep, nil
 }
 
-func (c *Config) newZipkinSampler() (zipkin.Sampler, error) {
-	var err error
-	var sampler zipkin.Sampler
-	switch c.Sampler.Type {
-	case NeverSampler:
-		sampler = zipkin.NeverSample
-	case AlwaysSampler:
-		sampler = zipkin.AlwaysSample
-	case ModuloSampler:
-		sampler = zipkin.NewModuloSampler(c.Sampler.Modulo.Mod)
-	case BoundarySampler:
-		sampler, err = zipkin.NewBoundarySampler(c.Sampler.Boundary.Rate, c.Sampler.Boundary.Salt)
-		if err != nil {
-			return nil, samplerInitErr(BoundarySampler, err)
+// Validate checks the configuration and returns a ConfigErrors holding
+// every invalid field, or nil if the configuration is valid.
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+	c.validate(&errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (c *Config) validate(errs *ConfigErrors) {
+	if hostPort := c.HostPort; hostPort != "" {
+		if strings.IndexByte(hostPort, ':') < 0 {
+			hostPort += ":0"
 		}
-	case CountingSampler:
-		sampler, err = zipkin.NewCountingSampler(c.Sampler.Counting.Rate)
-		if err != nil {
-			return nil, samplerInitErr(CountingSampler, err)
+		if _, port, err := net.SplitHostPort(hostPort); err != nil {
+			errs.add("host_port", err.Error())
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			errs.add("host_port", "invalid port "+port)
 		}
-	default:
-		return nil, invalidConfigErr("sampler.type")
 	}
-	return sampler, nil
+	for _, f := range c.Propagation {
+		if _, err := newPropagator(f, ""); err != nil {
+			errs.add("propagation", err.Error())
+		}
+	}
+	if _, err := b3InjectOptions(c.B3InjectStyle); err != nil {
+		errs.add("b3_inject_style", err.Error())
+	}
+	if c.Baggage != nil {
+		c.Baggage.validate("baggage", errs)
+	}
+	if c.ForceSample != nil && c.ForceSample.Key == "" {
+		errs.add("force_sample.key", "missing")
+	}
+	if c.ParentPolicy != nil {
+		c.ParentPolicy.validate("parent_policy", errs)
+	}
+	validateIncomingContext("trust_incoming_context", c.TrustIncomingContext, errs)
+	if c.ShutdownTimeoutSeconds < 0 {
+		errs.add("shutdown_timeout_seconds", "must not be negative")
+	}
+	if c.Sampler == nil {
+		errs.add("sampler", "missing")
+	} else {
+		c.Sampler.validate("sampler", errs)
+	}
+	if c.Reporter == nil {
+		errs.add("reporter", "missing")
+	} else {
+		c.Reporter.validate("reporter", errs)
+	}
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := c.Services[name]
+		path := joinField("services", name)
+		if s == nil {
+			errs.add(path, "empty")
+			continue
+		}
+		if s.Sampler != nil {
+			s.Sampler.validate(joinField(path, "sampler"), errs)
+		}
+		if s.Reporter != nil {
+			s.Reporter.validate(joinField(path, "reporter"), errs)
+		}
+		if s.ParentPolicy != nil {
+			s.ParentPolicy.validate(joinField(path, "parent_policy"), errs)
+		}
+		validateIncomingContext(joinField(path, "trust_incoming_context"), s.TrustIncomingContext, errs)
+	}
+}
+
+// newZipkinSampler news the sampler selected by the sampler config, and the
+// sampler which should decide new traces by operation, if any.
+func (c *Config) newZipkinSampler() (zipkin.Sampler, operationSampler, error) {
+	if len(c.Sampler.Rules) == 0 {
+		return c.Sampler.newSampler(c.ServiceName)
+	}
+	s, err := newRuleSampler(c.Sampler, c.ServiceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.sample, s, nil
 }
 
 func (c *Config) withDefault() {
@@ -135,6 +330,53 @@ func (c *Config) withDefault() {
 	}
 }
 
+func (c *Config) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeoutSeconds > 0 {
+		return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// serviceConfig returns the config of the named service, which is the top-level
+// config with the overrides under services applied, and whether tracing is
+// enabled for the service.
+func (c *Config) serviceConfig(name string) (Config, bool) {
+	cfg := *c
+	cfg.Services = nil
+	s := c.Services[name]
+	if s == nil {
+		return cfg, true
+	}
+	if s.Enabled != nil && !*s.Enabled {
+		return cfg, false
+	}
+	if s.TraceID128 != nil {
+		cfg.TraceID128 = *s.TraceID128
+	}
+	if s.Sampler != nil {
+		cfg.Sampler = s.Sampler
+	}
+	if s.Reporter != nil {
+		cfg.Reporter = s.Reporter
+	}
+	if s.ParentPolicy != nil {
+		cfg.ParentPolicy = s.ParentPolicy
+	}
+	if s.TrustIncomingContext != "" {
+		cfg.TrustIncomingContext = s.TrustIncomingContext
+	}
+	if len(s.Tags) > 0 {
+		cfg.Tags = make(map[string]string, len(c.Tags)+len(s.Tags))
+		for k, v := range c.Tags {
+			cfg.Tags[k] = v
+		}
+		for k, v := range s.Tags {
+			cfg.Tags[k] = v
+		}
+	}
+	return cfg, true
+}
+
 func (c *Config) withServiceName(name string) {
 	if name != "" {
 		c.ServiceName = name
@@ -155,9 +397,116 @@ func invalidConfigErr(para string) error {
 	return fmt.Errorf("trpc-opentracing-zipkin: param [%s] invalid", para)
 }
 
+// FieldError describes an invalid config field.
+type FieldError struct {
+	// Field is the yaml path of the field, such as reporter.kafka.urls.
+	Field string
+	// Reason tells why the field is invalid.
+	Reason string
+}
+
+// Error implements error.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("param [%s] invalid: %s", e.Field, e.Reason)
+}
+
+// ConfigErrors holds all problems found by Config.Validate.
+type ConfigErrors []*FieldError
+
+// Error implements error.
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "trpc-opentracing-zipkin: invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (e *ConfigErrors) add(field, reason string) {
+	*e = append(*e, &FieldError{Field: field, Reason: reason})
+}
+
+func joinField(prefix, field string) string {
+	if prefix == "" {
+		return field
+	}
+	return prefix + "." + field
+}
+
+// BaggageConfig holds the configuration of baggage propagation
+type BaggageConfig struct {
+	// AllowedKeys lists the baggage keys carried across calls, all keys are allowed if empty.
+	AllowedKeys []string `yaml:"allowed_keys"`
+	// MaxItems limits the number of baggage items, defaults to 64.
+	MaxItems int `yaml:"max_items"`
+	// MaxItemSize limits the bytes of the key and value of a baggage item, defaults to 1024.
+	MaxItemSize int `yaml:"max_item_size"`
+	// MaxTotalSize limits the bytes of all baggage items, defaults to 8192.
+	MaxTotalSize int `yaml:"max_total_size"`
+	// TagKeys lists the baggage keys added as tags to server and client spans.
+	TagKeys []string `yaml:"tag_keys"`
+}
+
+func (c *BaggageConfig) validate(path string, errs *ConfigErrors) {
+	if c.MaxItems < 0 {
+		errs.add(joinField(path, "max_items"), "must not be negative")
+	}
+	if c.MaxItemSize < 0 {
+		errs.add(joinField(path, "max_item_size"), "must not be negative")
+	}
+	if c.MaxTotalSize < 0 {
+		errs.add(joinField(path, "max_total_size"), "must not be negative")
+	}
+}
+
+// ForceSampleConfig holds the configuration of forcing the sampling of a request
+type ForceSampleConfig struct {
+	// Key is the trpc metadata key or http header which forces sampling.
+	Key string `yaml:"key"`
+	// Secret is the value Key must carry, any non-empty value forces sampling if empty.
+	Secret string `yaml:"secret"`
+}
+
+func validateIncomingContext(path, trust string, errs *ConfigErrors) {
+	switch trust {
+	case "", TrustContext, IgnoreContext, LinkContext:
+	default:
+		errs.add(path, fmt.Sprintf("unknown value %q", trust))
+	}
+}
+
+// ParentPolicyConfig holds the policy on the sampling decision of the upstream.
+type ParentPolicyConfig struct {
+	// Policy can be: respect_parent (default) ignore_parent respect_parent_if_trusted
+	Policy string `yaml:"policy"`
+	// TrustedCallers lists the caller services whose decision is kept. The caller
+	// service name can be spoofed by any client, so combine it with TrustedCIDRs,
+	// in which case a caller must match both.
+	TrustedCallers []string `yaml:"trusted_callers"`
+	// TrustedCIDRs lists the networks of the remote addresses whose decision is kept.
+	TrustedCIDRs []string `yaml:"trusted_cidrs"`
+}
+
+func (c *ParentPolicyConfig) validate(path string, errs *ConfigErrors) {
+	switch c.Policy {
+	case "", RespectParent, IgnoreParent:
+	case RespectParentIfTrusted:
+		if len(c.TrustedCallers) == 0 && len(c.TrustedCIDRs) == 0 {
+			errs.add(joinField(path, "trusted_callers"), "trusted_callers or trusted_cidrs should be set")
+		}
+	default:
+		errs.add(joinField(path, "policy"), fmt.Sprintf("unknown policy %q", c.Policy))
+	}
+	for i, cidr := range c.TrustedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs.add(fmt.Sprintf("%s[%d]", joinField(path, "trusted_cidrs"), i), err.Error())
+		}
+	}
+}
+
 // SamplerConfig holds the sampler configuration
 type SamplerConfig struct {
-	// Type can be: Never Always Modulo Boundary Counting
+	// Type can be: Never Always Modulo Boundary Counting RateLimiting Remote
 	Type string `yaml:"type"`
 	// Modulo sampler
 	Modulo *ModuloSamplerConfig `yaml:"const"`
@@ -165,6 +514,127 @@ type SamplerConfig struct {
 	Boundary *BoundarySamplerConfig `yaml:"mix"`
 	// Counting sampler
 	Counting *CountingSamplerConfig `yaml:"counting"`
+	// RateLimiting sampler
+	RateLimiting *RateLimitingSamplerConfig `yaml:"ratelimiting"`
+	// Remote sampler
+	Remote *RemoteSamplerConfig `yaml:"remote"`
+	// Rules select the sampler of new traces by operation. The first matching
+	// rule is used, and the sampler above is the default rule.
+	Rules []*SamplingRuleConfig `yaml:"rules"`
+}
+
+// SamplingRuleConfig holds the configuration for a sampling rule. Empty fields match everything.
+type SamplingRuleConfig struct {
+	// Service matches the service serving the server span or making the client call.
+	Service string `yaml:"service"`
+	// RPCName matches the rpc name as selected by Match.
+	RPCName string `yaml:"rpc_name"`
+	// Match can be: exact (default) prefix glob
+	Match string `yaml:"match"`
+	// Caller matches the caller service of server spans, rules with it never match client spans.
+	Caller string `yaml:"caller"`
+	// Sampler samples the traces matched by the rule, it can not have rules.
+	Sampler *SamplerConfig `yaml:"sampler"`
+}
+
+func (c *SamplingRuleConfig) validate(path string, errs *ConfigErrors) {
+	switch c.Match {
+	case "", ExactMatch, PrefixMatch:
+	case GlobMatch:
+		if err := checkGlob(c.RPCName); err != nil {
+			errs.add(joinField(path, "rpc_name"), err.Error())
+		}
+	default:
+		errs.add(joinField(path, "match"), fmt.Sprintf("unknown match %q", c.Match))
+	}
+	if c.Sampler == nil {
+		errs.add(joinField(path, "sampler"), "missing")
+		return
+	}
+	if len(c.Sampler.Rules) > 0 {
+		errs.add(joinField(path, "sampler.rules"), "not allowed in rules")
+		return
+	}
+	c.Sampler.validate(joinField(path, "sampler"), errs)
+}
+
+// newSampler news the sampler of the config type for service, ignoring the
+// rules, and the sampler which should decide new traces by operation, if any.
+func (c *SamplerConfig) newSampler(service string) (zipkin.Sampler, operationSampler, error) {
+	var err error
+	var sampler zipkin.Sampler
+	switch c.Type {
+	case NeverSampler:
+		sampler = zipkin.NeverSample
+	case AlwaysSampler:
+		sampler = zipkin.AlwaysSample
+	case ModuloSampler:
+		sampler = zipkin.NewModuloSampler(c.Modulo.Mod)
+	case BoundarySampler:
+		sampler, err = zipkin.NewBoundarySampler(c.Boundary.Rate, c.Boundary.Salt)
+		if err != nil {
+			return nil, nil, samplerInitErr(BoundarySampler, err)
+		}
+	case CountingSampler:
+		sampler, err = zipkin.NewCountingSampler(c.Counting.Rate)
+		if err != nil {
+			return nil, nil, samplerInitErr(CountingSampler, err)
+		}
+	case RateLimitingSampler:
+		s, err := newRateLimitingSampler(c.RateLimiting)
+		if err != nil {
+			return nil, nil, samplerInitErr(RateLimitingSampler, err)
+		}
+		if s.hybrid() {
+			return s.sample, s, nil
+		}
+		sampler = s.sample
+	case RemoteSampler:
+		s := newRemoteSampler(c.Remote, service)
+		return s.sample, s, nil
+	default:
+		return nil, nil, invalidConfigErr("sampler.type")
+	}
+	return sampler, nil, nil
+}
+
+func (c *SamplerConfig) validate(path string, errs *ConfigErrors) {
+	for i, r := range c.Rules {
+		r.validate(fmt.Sprintf("%s.rules[%d]", path, i), errs)
+	}
+	switch c.Type {
+	case NeverSampler, AlwaysSampler:
+	case ModuloSampler:
+		if c.Modulo == nil {
+			errs.add(joinField(path, "const"), "missing")
+		}
+	case BoundarySampler:
+		if c.Boundary == nil {
+			errs.add(joinField(path, "mix"), "missing")
+		} else if r := c.Boundary.Rate; r != 0 && r != 1 && (r < 0.0001 || r > 1) {
+			errs.add(joinField(path, "mix.rate"), "should be 0.0 or between 0.0001 and 1")
+		}
+	case CountingSampler:
+		if c.Counting == nil {
+			errs.add(joinField(path, "counting"), "missing")
+		} else if r := c.Counting.Rate; r != 0 && r != 1 && (r < 0.01 || r > 1) {
+			errs.add(joinField(path, "counting.rate"), "should be 0.0 or between 0.01 and 1")
+		}
+	case RateLimitingSampler:
+		if c.RateLimiting == nil {
+			errs.add(joinField(path, "ratelimiting"), "missing")
+		} else {
+			c.RateLimiting.validate(joinField(path, "ratelimiting"), errs)
+		}
+	case RemoteSampler:
+		if c.Remote == nil {
+			errs.add(joinField(path, "remote"), "missing")
+		} else {
+			c.Remote.validate(joinField(path, "remote"), errs)
+		}
+	default:
+		errs.add(joinField(path, "type"), fmt.Sprintf("unknown sampler type %q", c.Type))
+	}
 }
 
 // ModuloSamplerConfig holds the configuration for modulo sampler
@@ -183,11 +653,44 @@ type CountingSamplerConfig struct {
 	Rate float64 `yaml:"rate"`
 }
 
+// RateLimitingSamplerConfig holds the configuration for rate limiting sampler
+type RateLimitingSamplerConfig struct {
+	// TracesPerSecond limits the number of sampled traces per second, fractions are allowed.
+	TracesPerSecond float64 `yaml:"traces_per_second"`
+	// MinPerSecondPerOperation enables the hybrid mode, where each operation is sampled
+	// at least so many times per second and then with Probability.
+	MinPerSecondPerOperation float64 `yaml:"min_per_second_per_operation"`
+	// Probability samples the traces beyond the guaranteed ones in the hybrid mode.
+	Probability float64 `yaml:"probability"`
+}
+
+func (c *RateLimitingSamplerConfig) validate(path string, errs *ConfigErrors) {
+	if c.TracesPerSecond < 0 {
+		errs.add(joinField(path, "traces_per_second"), "must not be negative")
+	}
+	if c.MinPerSecondPerOperation < 0 {
+		errs.add(joinField(path, "min_per_second_per_operation"), "must not be negative")
+	}
+	if c.TracesPerSecond == 0 && c.MinPerSecondPerOperation == 0 {
+		errs.add(joinField(path, "traces_per_second"), "should be greater than 0")
+	}
+	if r := c.Probability; r != 0 && r != 1 && (r < 0.0001 || r > 1) {
+		errs.add(joinField(path, "probability"), "should be 0.0 or between 0.0001 and 1")
+	}
+}
+
 // ReporterConfig holds the configuration for reporter
 type ReporterConfig struct {
 	Type  string               `yaml:"type"`
 	HTTP  *HTTPReporterConfig  `yaml:"http"`
 	Kafka *KafkaReporterConfig `yaml:"kafka"`
+	GRPC  *GRPCReporterConfig  `yaml:"grpc"`
+	TRPC  *TRPCReporterConfig  `yaml:"trpc"`
+	File  *FileReporterConfig  `yaml:"file"`
+	Log   *LogReporterConfig   `yaml:"log"`
+	// TailSampling buffers the spans of each trace before the reporter, and
+	// reports only the interesting traces and those at the base rate.
+	TailSampling *TailSamplingConfig `yaml:"tail_sampling"`
 }
 
 func (c *ReporterConfig) reporterConfig() reporterNewer {
@@ -196,6 +699,17 @@ func (c *ReporterConfig) reporterConfig() reporterNewer {
 		return c.HTTP
 	case KafkaReporter:
 		return c.Kafka
+	case GRPCReporter:
+		return c.GRPC
+	case TRPCReporter:
+		return c.TRPC
+	case FileReporter:
+		return c.File
+	case LogReporter:
+		if c.Log == nil {
+			return &LogReporterConfig{}
+		}
+		return c.Log
 	case NoopReporter:
 		return &NoopReporterConfig{}
 	default:
@@ -203,6 +717,82 @@ func (c *ReporterConfig) reporterConfig() reporterNewer {
 	}
 }
 
+func (c *ReporterConfig) validate(path string, errs *ConfigErrors) {
+	switch c.Type {
+	case HTTPReporter:
+		if c.HTTP == nil {
+			errs.add(joinField(path, "http"), "missing")
+		} else {
+			c.HTTP.validate(joinField(path, "http"), errs)
+		}
+	case KafkaReporter:
+		if c.Kafka == nil {
+			errs.add(joinField(path, "kafka"), "missing")
+		} else {
+			c.Kafka.validate(joinField(path, "kafka"), errs)
+		}
+	case GRPCReporter:
+		if c.GRPC == nil {
+			errs.add(joinField(path, "grpc"), "missing")
+		} else {
+			c.GRPC.validate(joinField(path, "grpc"), errs)
+		}
+	case TRPCReporter:
+		if c.TRPC == nil {
+			errs.add(joinField(path, "trpc"), "missing")
+		} else {
+			c.TRPC.validate(joinField(path, "trpc"), errs)
+		}
+	case FileReporter:
+		if c.File == nil {
+			errs.add(joinField(path, "file"), "missing")
+		} else {
+			c.File.validate(joinField(path, "file"), errs)
+		}
+	case LogReporter:
+		if c.Log != nil {
+			c.Log.validate(joinField(path, "log"), errs)
+		}
+	case NoopReporter:
+	default:
+		errs.add(joinField(path, "type"), fmt.Sprintf("unknown reporter type %q", c.Type))
+	}
+	if c.TailSampling != nil {
+		c.TailSampling.validate(joinField(path, "tail_sampling"), errs)
+	}
+}
+
+// TailSamplingConfig holds the configuration for tail sampling. The sampler
+// should sample all traces, which are then selected here.
+type TailSamplingConfig struct {
+	// WindowSeconds bounds how long the spans of a trace are buffered, defaults to 5.
+	WindowSeconds int `yaml:"window_seconds"`
+	// MaxSpans bounds the number of buffered spans, defaults to 100000. The
+	// oldest traces are decided early once it is exceeded.
+	MaxSpans int `yaml:"max_spans"`
+	// LatencyThresholdMilliseconds selects traces with a span lasting at least so long.
+	LatencyThresholdMilliseconds int `yaml:"latency_threshold_ms"`
+	// Tags selects traces with a span carrying any of the tags, an empty value matches any value.
+	Tags map[string]string `yaml:"tags"`
+	// BaseRate samples the traces not selected, traces with errors are always selected.
+	BaseRate float64 `yaml:"base_rate"`
+}
+
+func (c *TailSamplingConfig) validate(path string, errs *ConfigErrors) {
+	if c.WindowSeconds < 0 {
+		errs.add(joinField(path, "window_seconds"), "must not be negative")
+	}
+	if c.MaxSpans < 0 {
+		errs.add(joinField(path, "max_spans"), "must not be negative")
+	}
+	if c.LatencyThresholdMilliseconds < 0 {
+		errs.add(joinField(path, "latency_threshold_ms"), "must not be negative")
+	}
+	if c.BaseRate < 0 || c.BaseRate > 1 {
+		errs.add(joinField(path, "base_rate"), "should be between 0 and 1")
+	}
+}
+
 type reporterNewer interface {
 	newReporter() (reporter.Reporter, error)
 }
@@ -218,13 +808,40 @@ type HTTPReporterConfig struct {
 	BatchIntervalSeconds int    `yaml:"batch_interval_seconds"`
 	BatchSize            int    `yaml:"batch_size"`
 	MaxBacklog           int    `yaml:"max_backlog"`
+	// Encoding of the spans: json (default) proto3
+	Encoding string `yaml:"encoding"`
+}
+
+func (c *HTTPReporterConfig) validate(path string, errs *ConfigErrors) {
+	if c.Url == "" {
+		errs.add(joinField(path, "url"), "missing")
+	}
+	if c.TimeoutSeconds < 0 {
+		errs.add(joinField(path, "time_out_seconds"), "must not be negative")
+	}
+	if c.BatchIntervalSeconds < 0 {
+		errs.add(joinField(path, "batch_interval_seconds"), "must not be negative")
+	}
+	if c.BatchSize < 0 {
+		errs.add(joinField(path, "batch_size"), "must not be negative")
+	}
+	if c.MaxBacklog < 0 {
+		errs.add(joinField(path, "max_backlog"), "must not be negative")
+	}
+	if _, err := newSpanSerializer(c.Encoding); err != nil {
+		errs.add(joinField(path, "encoding"), err.Error())
+	}
 }
 
 func (c *HTTPReporterConfig) newReporter() (reporter.Reporter, error) {
 	if c.Url == "" {
 		return nil, invalidConfigErr("reporter.http.url")
 	}
-	return http.NewReporter(c.Url, c.newReporterOption()...), nil
+	serializer, err := newSpanSerializer(c.Encoding)
+	if err != nil {
+		return nil, invalidConfigErr("reporter.http.encoding")
+	}
+	return http.NewReporter(c.Url, append(c.newReporterOption(), http.Serializer(serializer))...), nil
 }
 
 func (c *HTTPReporterConfig) newReporterOption() []http.ReporterOption {
@@ -248,6 +865,8 @@ func (c *HTTPReporterConfig) newReporterOption() []http.ReporterOption {
 type KafkaReporterConfig struct {
 	Urls                []string                  `yaml:"urls"`
 	ProducerFlushConfig *KafkaProducerFlushConfig `yaml:"producer_flush_config"`
+	// Encoding of the spans: json (default) proto3
+	Encoding string `yaml:"encoding"`
 }
 
 // KafkaProducerFlushConfig holds the configuration for  kafka producer
@@ -267,14 +886,41 @@ type KafkaProducerFlushConfig struct {
 	MaxMessages int `yaml:"max_messages"`
 }
 
+func (c *KafkaReporterConfig) validate(path string, errs *ConfigErrors) {
+	if len(c.Urls) == 0 {
+		errs.add(joinField(path, "urls"), "missing")
+	}
+	if _, err := newSpanSerializer(c.Encoding); err != nil {
+		errs.add(joinField(path, "encoding"), err.Error())
+	}
+}
+
 func (c *KafkaReporterConfig) newReporter() (reporter.Reporter, error) {
 	if len(c.Urls) == 0 {
-		return nil, invalidConfigErr("reporter.kafka.url")
+		return nil, invalidConfigErr("reporter.kafka.urls")
 	}
-	if c.ProducerFlushConfig == nil {
+	serializer, err := newSpanSerializer(c.Encoding)
+	if err != nil {
+		return nil, invalidConfigErr("reporter.kafka.encoding")
+	}
+	if c.ProducerFlushConfig == nil && c.Encoding != Proto3Encoding {
 		return kafka.NewReporter(c.Urls)
 	}
+	producer, err := sarama.NewAsyncProducer(c.Urls, c.newProducerConfig())
+	if err != nil {
+		return nil, err
+	}
+	if c.Encoding == Proto3Encoding {
+		return newKafkaReporter(producer, serializer), nil
+	}
+	return kafka.NewReporter(c.Urls, kafka.Producer(producer))
+}
+
+func (c *KafkaReporterConfig) newProducerConfig() *sarama.Config {
 	conf := sarama.NewConfig()
+	if c.ProducerFlushConfig == nil {
+		return conf
+	}
 	conf.Producer.Flush = struct {
 		Bytes       int
 		Messages    int
@@ -286,11 +932,228 @@ func (c *KafkaReporterConfig) newReporter() (reporter.Reporter, error) {
 		Frequency:   c.ProducerFlushConfig.Frequency,
 		MaxMessages: c.ProducerFlushConfig.MaxMessages,
 	}
-	producer, err := sarama.NewAsyncProducer(c.Urls, conf)
+	return conf
+}
+
+// GRPCReporterConfig holds the configuration for grpc reporter, which sends
+// spans to zipkin.proto3.SpanService/Report with the defaults of the http reporter.
+type GRPCReporterConfig struct {
+	// Address is the host:port of the zipkin grpc collector.
+	Address              string `yaml:"address"`
+	TimeoutSeconds       int    `yaml:"time_out_seconds"`
+	BatchIntervalSeconds int    `yaml:"batch_interval_seconds"`
+	BatchSize            int    `yaml:"batch_size"`
+	MaxBacklog           int    `yaml:"max_backlog"`
+	// TLS enables TLS to the collector, which is plaintext if not set.
+	TLS *TLSConfig `yaml:"tls"`
+}
+
+func (c *GRPCReporterConfig) validate(path string, errs *ConfigErrors) {
+	if c.Address == "" {
+		errs.add(joinField(path, "address"), "missing")
+	}
+	if c.TimeoutSeconds < 0 {
+		errs.add(joinField(path, "time_out_seconds"), "must not be negative")
+	}
+	if c.BatchIntervalSeconds < 0 {
+		errs.add(joinField(path, "batch_interval_seconds"), "must not be negative")
+	}
+	if c.BatchSize < 0 {
+		errs.add(joinField(path, "batch_size"), "must not be negative")
+	}
+	if c.MaxBacklog < 0 {
+		errs.add(joinField(path, "max_backlog"), "must not be negative")
+	}
+	if c.TLS != nil {
+		c.TLS.validate(joinField(path, "tls"), errs)
+	}
+}
+
+func (c *GRPCReporterConfig) newReporter() (reporter.Reporter, error) {
+	if c.Address == "" {
+		return nil, invalidConfigErr("reporter.grpc.address")
+	}
+	opt := grpc.WithInsecure()
+	if c.TLS != nil {
+		tlsConf, err := c.TLS.newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(tlsConf))
+	}
+	// the connection is established in the background
+	conn, err := grpc.Dial(c.Address, opt)
 	if err != nil {
 		return nil, err
 	}
-	return kafka.NewReporter(c.Urls, kafka.Producer(producer))
+	return newGRPCReporter(conn, c), nil
+}
+
+// TRPCReporterConfig holds the configuration for trpc reporter, which sends
+// spans to the http api of the collector through a trpc-go http client, so that
+// trpc naming, selectors and the client config of name apply.
+type TRPCReporterConfig struct {
+	// Target of the collector, such as polaris://zipkin.collector.
+	Target string `yaml:"target"`
+	// Name is the callee service name of the calls, which selects the trpc client
+	// config of the same name, defaults to trpc.zipkin.collector.
+	Name string `yaml:"name"`
+	// Path of the span api, defaults to /api/v2/spans.
+	Path                 string `yaml:"path"`
+	TimeoutSeconds       int    `yaml:"time_out_seconds"`
+	BatchIntervalSeconds int    `yaml:"batch_interval_seconds"`
+	BatchSize            int    `yaml:"batch_size"`
+	MaxBacklog           int    `yaml:"max_backlog"`
+	// Encoding of the spans: json (default) proto3
+	Encoding string `yaml:"encoding"`
+}
+
+func (c *TRPCReporterConfig) validate(path string, errs *ConfigErrors) {
+	if c.Target == "" && c.Name == "" {
+		errs.add(joinField(path, "target"), "target or name should be set")
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		errs.add(joinField(path, "path"), "should start with /")
+	}
+	if c.TimeoutSeconds < 0 {
+		errs.add(joinField(path, "time_out_seconds"), "must not be negative")
+	}
+	if c.BatchIntervalSeconds < 0 {
+		errs.add(joinField(path, "batch_interval_seconds"), "must not be negative")
+	}
+	if c.BatchSize < 0 {
+		errs.add(joinField(path, "batch_size"), "must not be negative")
+	}
+	if c.MaxBacklog < 0 {
+		errs.add(joinField(path, "max_backlog"), "must not be negative")
+	}
+	if _, err := newSpanSerializer(c.Encoding); err != nil {
+		errs.add(joinField(path, "encoding"), err.Error())
+	}
+}
+
+func (c *TRPCReporterConfig) newReporter() (reporter.Reporter, error) {
+	return newTRPCReporter(c)
+}
+
+// FileReporterConfig holds the configuration for file reporter, which writes
+// each span as a line of zipkin v2 JSON.
+type FileReporterConfig struct {
+	Path string `yaml:"path"`
+	// MaxSizeMB rotates the file once it would exceed so many megabytes, 0 disables it.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// RotationIntervalSeconds rotates the file once it is open for so long, 0 disables it.
+	RotationIntervalSeconds int `yaml:"rotation_interval_seconds"`
+	// MaxBackups bounds the number of rotated files, all are kept if 0.
+	MaxBackups int `yaml:"max_backups"`
+	// Compress gzips the rotated files.
+	Compress bool `yaml:"compress"`
+	// Fsync can be: never (default) always interval
+	Fsync                string `yaml:"fsync"`
+	FsyncIntervalSeconds int    `yaml:"fsync_interval_seconds"`
+	// MaxBacklog bounds the spans pending to be written, defaults to 1000.
+	MaxBacklog int `yaml:"max_backlog"`
+}
+
+func (c *FileReporterConfig) validate(path string, errs *ConfigErrors) {
+	if c.Path == "" {
+		errs.add(joinField(path, "path"), "missing")
+	}
+	if c.MaxSizeMB < 0 {
+		errs.add(joinField(path, "max_size_mb"), "must not be negative")
+	}
+	if c.RotationIntervalSeconds < 0 {
+		errs.add(joinField(path, "rotation_interval_seconds"), "must not be negative")
+	}
+	if c.MaxBackups < 0 {
+		errs.add(joinField(path, "max_backups"), "must not be negative")
+	}
+	switch c.Fsync {
+	case "", FsyncNever, FsyncAlways, FsyncInterval:
+	default:
+		errs.add(joinField(path, "fsync"), fmt.Sprintf("unknown fsync policy %q", c.Fsync))
+	}
+	if c.FsyncIntervalSeconds < 0 {
+		errs.add(joinField(path, "fsync_interval_seconds"), "must not be negative")
+	}
+	if c.MaxBacklog < 0 {
+		errs.add(joinField(path, "max_backlog"), "must not be negative")
+	}
+}
+
+func (c *FileReporterConfig) newReporter() (reporter.Reporter, error) {
+	if c.Path == "" {
+		return nil, invalidConfigErr("reporter.file.path")
+	}
+	return newFileReporter(c).start(), nil
+}
+
+// LogReporterConfig holds the configuration for log reporter, which logs spans
+// for local development.
+type LogReporterConfig struct {
+	// Format can be: compact (default) tree
+	Format string `yaml:"format"`
+	// Level can be: trace debug info (default) warn error
+	Level string `yaml:"level"`
+	// Logger is the name of the trpc logger, defaults to the default logger.
+	Logger string `yaml:"logger"`
+}
+
+func (c *LogReporterConfig) validate(path string, errs *ConfigErrors) {
+	switch c.Format {
+	case "", CompactLogFormat, TreeLogFormat:
+	default:
+		errs.add(joinField(path, "format"), fmt.Sprintf("unknown format %q", c.Format))
+	}
+	switch c.Level {
+	case "", "trace", "debug", "info", "warn", "error":
+	default:
+		errs.add(joinField(path, "level"), fmt.Sprintf("unknown level %q", c.Level))
+	}
+}
+
+func (c *LogReporterConfig) newReporter() (reporter.Reporter, error) {
+	return newLogReporter(c), nil
+}
+
+// TLSConfig holds the TLS configuration of a client.
+type TLSConfig struct {
+	// CAFile verifies the server certificate, the system roots are used if empty.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile hold the client certificate, if any.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the name verified in the server certificate.
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (c *TLSConfig) validate(path string, errs *ConfigErrors) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs.add(joinField(path, "cert_file"), "cert_file and key_file should be set together")
+	}
+}
+
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	conf := &tls.Config{ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
 }
 
 // NoopReporterConfig holds the configuration for noop reporter
@@ -300,3 +1163,38 @@ type NoopReporterConfig struct {
 func (c *NoopReporterConfig) newReporter() (reporter.Reporter, error) {
 	return reporter.NewNoopReporter(), nil
 }
+
+// RemoteSamplerConfig holds the configuration for remote sampler. The strategy
+// is a JSON document in the shape of the Jaeger sampling strategies.
+type RemoteSamplerConfig struct {
+	// URL of the http endpoint serving the strategy, the service name is added
+	// as the service parameter if absent.
+	URL string `yaml:"url"`
+	// File holds the strategy, either of the service or of many services.
+	File string `yaml:"file"`
+	// PollIntervalSeconds defaults to 60.
+	PollIntervalSeconds int `yaml:"poll_interval_seconds"`
+	// TimeoutSeconds bounds each http request, defaults to 5.
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+	// InitialRate samples traces until a strategy is loaded, defaults to 0.001.
+	InitialRate *float64 `yaml:"initial_rate"`
+}
+
+func (c *RemoteSamplerConfig) validate(path string, errs *ConfigErrors) {
+	if (c.URL == "") == (c.File == "") {
+		errs.add(joinField(path, "url"), "exactly one of url and file should be set")
+	} else if c.URL != "" {
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs.add(joinField(path, "url"), "should be an http or https url")
+		}
+	}
+	if c.PollIntervalSeconds < 0 {
+		errs.add(joinField(path, "poll_interval_seconds"), "must not be negative")
+	}
+	if c.TimeoutSeconds < 0 {
+		errs.add(joinField(path, "timeout_seconds"), "must not be negative")
+	}
+	if r := c.InitialRate; r != nil && (*r < 0 || *r > 1) {
+		errs.add(joinField(path, "initial_rate"), "should be between 0 and 1")
+	}
+}
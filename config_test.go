@@ -247,6 +247,41 @@ func TestConfigSetDefault(t *testing.T) {
 	assert.Equal(t, "127.0.0.1:8080", c.HostPort)
 }
 
+func TestConfig_serviceConfig(t *testing.T) {
+	disabled, enabled := false, true
+	c := Config{
+		ServiceName: "server",
+		Sampler:     &SamplerConfig{Type: AlwaysSampler},
+		Reporter:    &ReporterConfig{Type: NoopReporter},
+		Tags:        map[string]string{"env": "test", "team": "a"},
+		Services: map[string]*ServiceConfig{
+			"public": {
+				Sampler:    &SamplerConfig{Type: NeverSampler},
+				TraceID128: &enabled,
+				Tags:       map[string]string{"team": "b"},
+			},
+			"health": {Enabled: &disabled},
+		},
+	}
+
+	cfg, ok := c.serviceConfig("public")
+	assert.True(t, ok)
+	assert.Equal(t, NeverSampler, cfg.Sampler.Type)
+	assert.True(t, cfg.Reporter == c.Reporter)
+	assert.True(t, cfg.TraceID128)
+	assert.Nil(t, cfg.Services)
+	assert.Equal(t, map[string]string{"env": "test", "team": "b"}, cfg.Tags)
+	assert.Equal(t, "a", c.Tags["team"])
+
+	_, ok = c.serviceConfig("health")
+	assert.False(t, ok)
+
+	cfg, ok = c.serviceConfig("other")
+	assert.True(t, ok)
+	assert.True(t, cfg.Sampler == c.Sampler)
+	assert.False(t, cfg.TraceID128)
+}
+
 func Test_KafkaNewReporter(t *testing.T) {
 	type args struct {
 		urls                []string
@@ -327,3 +362,65 @@ func Test_invalidConfigErr(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_Validate(t *testing.T) {
+	c := &Config{
+		Sampler: &SamplerConfig{Type: NeverSampler},
+		Reporter: &ReporterConfig{
+			Type: HTTPReporter,
+			HTTP: &HTTPReporterConfig{Url: "http://localhost:9411/api/v2/spans"},
+		},
+	}
+	assert.Nil(t, c.Validate())
+
+	c = &Config{
+		HostPort:               "127.0.0.1:port",
+		Propagation:            []string{W3CPropagation, "xray"},
+		ShutdownTimeoutSeconds: -1,
+		Sampler: &SamplerConfig{
+			Type:     CountingSampler,
+			Counting: &CountingSamplerConfig{Rate: 2},
+		},
+		Reporter: &ReporterConfig{
+			Type:  KafkaReporter,
+			Kafka: &KafkaReporterConfig{},
+		},
+	}
+	err := c.Validate()
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	var fields []string
+	for _, fe := range errs {
+		fields = append(fields, fe.Field)
+	}
+	assert.Equal(t, []string{
+		"host_port",
+		"propagation",
+		"shutdown_timeout_seconds",
+		"sampler.counting.rate",
+		"reporter.kafka.urls",
+	}, fields)
+	assert.Contains(t, err.Error(), "param [reporter.kafka.urls] invalid: missing")
+
+	err = (&Config{}).Validate()
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+
+	err = (&Config{
+		Sampler:  &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: NoopReporter},
+		Services: map[string]*ServiceConfig{
+			"b": {Reporter: &ReporterConfig{Type: HTTPReporter}},
+			"a": {Sampler: &SamplerConfig{Type: BoundarySampler}},
+		},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		"param [services.a.sampler.mix] invalid: missing; param [services.b.reporter.http] invalid: missing")
+
+	err = (&Config{
+		Sampler:  &SamplerConfig{Type: ModuloSampler},
+		Reporter: &ReporterConfig{Type: "udp"},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		"param [sampler.const] invalid: missing; param [reporter.type] invalid: unknown reporter type \"udp\"")
+}
@@ -0,0 +1,82 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/openzipkin/zipkin-go/model"
+	zipkinProto "github.com/openzipkin/zipkin-go/proto/v2"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// Encodings of the spans sent by reporters.
+const (
+	// JSONEncoding is the zipkin v2 JSON encoding.
+	JSONEncoding = "json"
+	// Proto3Encoding is the zipkin v2 proto3 encoding, a ListOfSpans message.
+	Proto3Encoding = "proto3"
+
+	defaultKafkaTopic = "zipkin"
+)
+
+// newSpanSerializer returns the serializer of encoding, which defaults to json.
+func newSpanSerializer(encoding string) (reporter.SpanSerializer, error) {
+	switch encoding {
+	case "", JSONEncoding:
+		return reporter.JSONSerializer{}, nil
+	case Proto3Encoding:
+		return zipkinProto.SpanSerializer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// kafkaReporter sends each span as a kafka message encoded by serializer,
+// as the zipkin-go kafka reporter always encodes spans to JSON.
+type kafkaReporter struct {
+	producer   sarama.AsyncProducer
+	serializer reporter.SpanSerializer
+	topic      string
+}
+
+func newKafkaReporter(producer sarama.AsyncProducer, serializer reporter.SpanSerializer) *kafkaReporter {
+	r := &kafkaReporter{producer: producer, serializer: serializer, topic: defaultKafkaTopic}
+	go r.logErrors()
+	return r
+}
+
+func (r *kafkaReporter) logErrors() {
+	for pe := range r.producer.Errors() {
+		log.Errorf("trpc-opentracing-zipkin: failed to produce spans: %v", pe.Err)
+	}
+}
+
+// Send implements reporter.Reporter.
+func (r *kafkaReporter) Send(s model.SpanModel) {
+	// Zipkin expects the message to hold a list of spans
+	b, err := r.serializer.Serialize([]*model.SpanModel{&s})
+	if err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to serialize span: %v", err)
+		return
+	}
+	r.producer.Input() <- &sarama.ProducerMessage{Topic: r.topic, Value: sarama.ByteEncoder(b)}
+}
+
+// Close implements reporter.Reporter.
+func (r *kafkaReporter) Close() error {
+	return r.producer.Close()
+}
@@ -0,0 +1,133 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama/mocks"
+	"github.com/openzipkin/zipkin-go/model"
+	zipkinProto "github.com/openzipkin/zipkin-go/proto/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEncodingTestSpan() model.SpanModel {
+	parent := model.ID(1)
+	return model.SpanModel{
+		SpanContext: model.SpanContext{
+			TraceID:  model.TraceID{High: 1, Low: 2},
+			ID:       2,
+			ParentID: &parent,
+		},
+		Name:           "/trpc.app.server.Service/Hello",
+		Kind:           model.Server,
+		Timestamp:      time.Unix(1600000000, 0).UTC(),
+		Duration:       time.Millisecond,
+		LocalEndpoint:  &model.Endpoint{ServiceName: "trpc.app.server.Service"},
+		RemoteEndpoint: &model.Endpoint{ServiceName: "trpc.app.client.Service"},
+		Annotations:    []model.Annotation{{Timestamp: time.Unix(1600000000, 0).UTC(), Value: "event=error"}},
+		Tags:           map[string]string{TagRetCode: "0"},
+	}
+}
+
+// decodeSpans decodes a payload of encoding, with timestamps in UTC.
+func decodeSpans(t *testing.T, encoding string, b []byte) []*model.SpanModel {
+	var spans []*model.SpanModel
+	if encoding == Proto3Encoding {
+		var err error
+		spans, err = zipkinProto.ParseSpans(b, false)
+		assert.Nil(t, err)
+	} else {
+		assert.Nil(t, json.Unmarshal(b, &spans))
+	}
+	for _, s := range spans {
+		s.Timestamp = s.Timestamp.UTC()
+		for i := range s.Annotations {
+			s.Annotations[i].Timestamp = s.Annotations[i].Timestamp.UTC()
+		}
+	}
+	return spans
+}
+
+func TestHTTPReporter_Encoding(t *testing.T) {
+	tests := []struct {
+		encoding        string
+		wantContentType string
+	}{
+		{"", "application/json"},
+		{JSONEncoding, "application/json"},
+		{Proto3Encoding, "application/x-protobuf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			received := make(chan []*model.SpanModel, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.wantContentType, r.Header.Get("Content-Type"))
+				b, err := ioutil.ReadAll(r.Body)
+				assert.Nil(t, err)
+				received <- decodeSpans(t, tt.encoding, b)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer srv.Close()
+
+			rep, err := (&HTTPReporterConfig{Url: srv.URL, Encoding: tt.encoding}).newReporter()
+			assert.Nil(t, err)
+			want := newEncodingTestSpan()
+			rep.Send(want)
+			assert.Nil(t, rep.Close())
+
+			select {
+			case spans := <-received:
+				assert.Len(t, spans, 1)
+				assert.Equal(t, want, *spans[0])
+			case <-time.After(5 * time.Second):
+				t.Fatal("no spans received")
+			}
+		})
+	}
+}
+
+func TestKafkaReporter_Encoding(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, nil)
+	want := newEncodingTestSpan()
+	producer.ExpectInputWithCheckerFunctionAndSucceed(func(b []byte) error {
+		spans := decodeSpans(t, Proto3Encoding, b)
+		assert.Len(t, spans, 1)
+		assert.Equal(t, want, *spans[0])
+		return nil
+	})
+	serializer, err := newSpanSerializer(Proto3Encoding)
+	assert.Nil(t, err)
+	rep := newKafkaReporter(producer, serializer)
+	rep.Send(want)
+	assert.Nil(t, rep.Close())
+}
+
+func TestConfig_ValidateEncoding(t *testing.T) {
+	err := (&Config{
+		Sampler: &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{
+			Type:  HTTPReporter,
+			HTTP:  &HTTPReporterConfig{Url: "http://localhost:9411/api/v2/spans", Encoding: "thrift"},
+			Kafka: &KafkaReporterConfig{Urls: []string{"localhost:9092"}, Encoding: Proto3Encoding},
+		},
+	}).Validate()
+	assert.EqualError(t, err,
+		`trpc-opentracing-zipkin: invalid config: param [reporter.http.encoding] invalid: unknown encoding "thrift"`)
+}
@@ -0,0 +1,94 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// zipkin-go-opentracing only takes the remote endpoint of a span from the peer tags
+// given at span creation, while the address of the callee is known only after the
+// selector has picked a node. Such endpoints are carried by the span itself in this
+// tag until the finished span reaches the remoteEndpointReporter of its tracer.
+const remoteEndpointTag = "trpc.internal.remote_endpoint"
+
+// setRemoteEndpoint records the remote endpoint of span, which replaces the one
+// given at span creation when the span is reported.
+func setRemoteEndpoint(span opentracing.Span, ep *model.Endpoint) {
+	if ep.Empty() {
+		return
+	}
+	b, err := json.Marshal(ep)
+	if err != nil {
+		return
+	}
+	span.SetTag(remoteEndpointTag, string(b))
+}
+
+// remoteEndpointReporter fills the remote endpoint recorded by setRemoteEndpoint
+// into spans before sending them.
+type remoteEndpointReporter struct {
+	reporter.Reporter
+}
+
+// Send implements reporter.Reporter.
+func (r remoteEndpointReporter) Send(s model.SpanModel) {
+	if v, ok := s.Tags[remoteEndpointTag]; ok {
+		tags := make(map[string]string, len(s.Tags)-1)
+		for k, v := range s.Tags {
+			if k != remoteEndpointTag {
+				tags[k] = v
+			}
+		}
+		s.Tags = tags
+		var ep model.Endpoint
+		if err := json.Unmarshal([]byte(v), &ep); err == nil {
+			ep.IPv4 = ep.IPv4.To4()
+			if ep.ServiceName == "" && s.RemoteEndpoint != nil {
+				ep.ServiceName = s.RemoteEndpoint.ServiceName
+			}
+			s.RemoteEndpoint = &ep
+		}
+	}
+	r.Reporter.Send(s)
+}
+
+// peerEndpoint returns the endpoint of the service serving on addr.
+func peerEndpoint(serviceName string, addr net.Addr) *model.Endpoint {
+	ep := &model.Endpoint{ServiceName: serviceName}
+	if addr == nil {
+		return ep
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ep
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+		ep.Port = uint16(p)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ep.IPv4 = ip4
+		} else {
+			ep.IPv6 = ip
+		}
+	}
+	return ep
+}
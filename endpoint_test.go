@@ -0,0 +1,76 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_peerEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want *model.Endpoint
+	}{
+		{"nil addr", nil, &model.Endpoint{ServiceName: "callee"}},
+		{
+			"ipv4",
+			&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8000},
+			&model.Endpoint{ServiceName: "callee", IPv4: net.ParseIP("10.0.0.1").To4(), Port: 8000},
+		},
+		{
+			"ipv6",
+			&net.UDPAddr{IP: net.ParseIP("::1"), Port: 8000},
+			&model.Endpoint{ServiceName: "callee", IPv6: net.ParseIP("::1"), Port: 8000},
+		},
+		{"unix", &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, &model.Endpoint{ServiceName: "callee"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, peerEndpoint("callee", tt.addr))
+		})
+	}
+}
+
+func Test_setRemoteEndpoint(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "caller", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+
+	span := tracer.StartSpan("call", opentracing.Tag{Key: string(ext.PeerService), Value: "callee"})
+	setRemoteEndpoint(span, &model.Endpoint{IPv4: net.ParseIP("10.0.0.1").To4(), Port: 80})
+	span.Finish()
+	// a server span sharing the span id keeps its own remote endpoint
+	server := tracer.StartSpan("call", ext.RPCServerOption(span.Context()))
+	server.Finish()
+
+	spans := rec.Flush()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, &model.Endpoint{ServiceName: "callee", IPv4: net.ParseIP("10.0.0.1").To4(), Port: 80},
+		spans[0].RemoteEndpoint)
+	assert.NotContains(t, spans[0].Tags, remoteEndpointTag)
+	assert.Equal(t, spans[0].ID, spans[1].ID)
+	assert.Nil(t, spans[1].RemoteEndpoint)
+
+	// spans of other tracers are left untouched
+	setRemoteEndpoint(opentracing.NoopTracer{}.StartSpan("call"), &model.Endpoint{ServiceName: "callee"})
+}
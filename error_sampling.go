@@ -0,0 +1,135 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	traceLog "github.com/opentracing/opentracing-go/log"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// Tags linking a span reported by error sampling to the unsampled trace it
+// belongs to.
+const (
+	TagLinkedTraceID = "trpc.linked_trace_id"
+	TagLinkedSpanID  = "trpc.linked_span_id"
+)
+
+// sampleErrors wraps span so that it is reported as a standalone root span if
+// it ends with an error while its trace is not sampled. The tags in opts are
+// the tags given to span at start.
+func (z *zipkinPlugin) sampleErrors(tracer opentracing.Tracer, span opentracing.Span, name string,
+	opts ...opentracing.StartSpanOption) opentracing.Span {
+	if !z.errorSampling {
+		return span
+	}
+	sc, ok := span.Context().(zipkinOpentracing.SpanContext)
+	if !ok || sc.Debug || (sc.Sampled != nil && *sc.Sampled) {
+		return span
+	}
+	s := &errorSampledSpan{
+		Span:   span,
+		tracer: tracer,
+		name:   name,
+		start:  time.Now(),
+		tags:   make(map[string]interface{}),
+	}
+	for _, o := range opts {
+		if tag, ok := o.(opentracing.Tag); ok {
+			s.tags[tag.Key] = tag.Value
+		}
+	}
+	return s
+}
+
+// errorSampledSpan records what is set on an unsampled span, and reports it
+// on a new sampled root span if the error tag is set when it is finished.
+type errorSampledSpan struct {
+	opentracing.Span
+	tracer opentracing.Tracer
+	start  time.Time
+
+	mu   sync.Mutex
+	name string
+	tags map[string]interface{}
+	logs []opentracing.LogRecord
+}
+
+// SetOperationName implements opentracing.Span.
+func (s *errorSampledSpan) SetOperationName(name string) opentracing.Span {
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+	s.Span.SetOperationName(name)
+	return s
+}
+
+// SetTag implements opentracing.Span.
+func (s *errorSampledSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	s.tags[key] = value
+	s.mu.Unlock()
+	s.Span.SetTag(key, value)
+	return s
+}
+
+// LogFields implements opentracing.Span.
+func (s *errorSampledSpan) LogFields(fields ...traceLog.Field) {
+	s.mu.Lock()
+	s.logs = append(s.logs, opentracing.LogRecord{Timestamp: time.Now(), Fields: fields})
+	s.mu.Unlock()
+	s.Span.LogFields(fields...)
+}
+
+// Finish implements opentracing.Span.
+func (s *errorSampledSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+// FinishWithOptions implements opentracing.Span.
+func (s *errorSampledSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.Span.FinishWithOptions(opts)
+	if opts.FinishTime.IsZero() {
+		opts.FinishTime = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if failed, _ := s.tags[string(ext.Error)].(bool); failed {
+		s.report(opts)
+	}
+}
+
+// report reports the span on a new sampled root span.
+func (s *errorSampledSpan) report(opts opentracing.FinishOptions) {
+	sc := s.Span.Context().(zipkinOpentracing.SpanContext)
+	sampled := true
+	startOpts := []opentracing.StartSpanOption{
+		// a parent without trace id only carries the sampling decision
+		opentracing.ChildOf(zipkinOpentracing.SpanContext(model.SpanContext{Sampled: &sampled})),
+		opentracing.StartTime(s.start),
+		opentracing.Tag{Key: TagLinkedTraceID, Value: sc.TraceID.String()},
+		opentracing.Tag{Key: TagLinkedSpanID, Value: sc.ID.String()},
+	}
+	for k, v := range s.tags {
+		startOpts = append(startOpts, opentracing.Tag{Key: k, Value: v})
+	}
+	span := s.tracer.StartSpan(s.name, startOpts...)
+	opts.LogRecords = append(s.logs, opts.LogRecords...)
+	span.FinishWithOptions(opts)
+}
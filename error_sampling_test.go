@@ -0,0 +1,107 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+func TestFilter_ErrorSampling(t *testing.T) {
+	tests := []struct {
+		name          string
+		sampler       string
+		errorSampling bool
+		fail          bool
+		wantSpans     int
+		wantLinked    bool
+	}{
+		{"unsampled error", NeverSampler, true, true, 2, true},
+		{"unsampled success", NeverSampler, true, false, 0, false},
+		{"disabled", NeverSampler, false, true, 0, false},
+		{"sampled error", AlwaysSampler, true, true, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recorder.NewReporter()
+			c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: tt.sampler}}
+			tracer, err := c.newOpenTracingTracer(rec)
+			assert.Nil(t, err)
+			z := &zipkinPlugin{
+				tracers:       map[string]opentracing.Tracer{"trpc.app.server.Service": tracer},
+				errorSampling: tt.errorSampling,
+			}
+
+			var callErr error
+			if tt.fail {
+				callErr = errors.New("failed")
+			}
+			ctx, msg := codec.WithNewMessage(context.Background())
+			msg.WithCalleeServiceName("trpc.app.server.Service")
+			msg.WithServerRPCName("/trpc.app.server.Service/Hello")
+			var traceID model.TraceID
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				traceID = opentracing.SpanFromContext(ctx).Context().(zipkinOpentracing.SpanContext).TraceID
+				clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+				clientMsg.WithCallerServiceName("trpc.app.server.Service")
+				clientMsg.WithCalleeServiceName("trpc.app.backend.Service")
+				clientMsg.WithClientRPCName("/trpc.app.backend.Service/Get")
+				return nil, ClientFilter(z)(clientCtx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+					codec.Message(ctx).WithRemoteAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8000})
+					return callErr
+				})
+			}
+			_, err = ServerFilter(z)(ctx, nil, handler)
+			assert.Equal(t, callErr, err)
+
+			spans := rec.Flush()
+			assert.Len(t, spans, tt.wantSpans)
+			if tt.wantSpans == 0 {
+				return
+			}
+			client, server := spans[0], spans[1]
+			assert.Equal(t, model.Client, client.Kind)
+			assert.Equal(t, model.Server, server.Kind)
+			assert.Equal(t, "/trpc.app.backend.Service/Get", client.Name)
+			assert.Equal(t, "/trpc.app.server.Service/Hello", server.Name)
+			for _, s := range spans {
+				assert.Equal(t, "true", s.Tags["error"])
+				assert.Equal(t, "999", s.Tags[TagRetCode])
+			}
+			assert.Equal(t, "trpc.app.backend.Service", client.RemoteEndpoint.ServiceName)
+			assert.Equal(t, uint16(8000), client.RemoteEndpoint.Port)
+			if !tt.wantLinked {
+				assert.NotContains(t, server.Tags, TagLinkedTraceID)
+				return
+			}
+			// each failed call is a root span linked to the unsampled trace
+			assert.Nil(t, client.ParentID)
+			assert.Nil(t, server.ParentID)
+			assert.NotEqual(t, client.TraceID, server.TraceID)
+			assert.Equal(t, traceID.String(), server.Tags[TagLinkedTraceID])
+			assert.Equal(t, traceID.String(), client.Tags[TagLinkedTraceID])
+			assert.NotEqual(t, server.Tags[TagLinkedSpanID], client.Tags[TagLinkedSpanID])
+			assert.Len(t, server.Annotations, 2)
+		})
+	}
+}
@@ -0,0 +1,332 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// Fsync policies of the file reporter.
+const (
+	// FsyncNever leaves flushing the written spans to disk to the OS.
+	FsyncNever = "never"
+	// FsyncAlways syncs the file whenever the pending spans are written.
+	FsyncAlways = "always"
+	// FsyncInterval syncs the file periodically.
+	FsyncInterval = "interval"
+
+	defaultFileReporterBacklog = 1000
+	defaultFsyncInterval       = time.Second
+	// backupTimeFormat suffixes the rotated files, which sort by name in time order.
+	// Files rotated within the same millisecond are further suffixed by -{seq}.
+	backupTimeFormat = "20060102T150405.000"
+	// fileReporterTick is the period of time based rotation, fsync and warnings.
+	fileReporterTick = time.Second
+)
+
+// fileReporter writes each span as a line of zipkin v2 JSON to a file, which
+// is rotated by size and time. Spans are written in the background, and those
+// exceeding the backlog are dropped.
+type fileReporter struct {
+	path          string
+	maxSize       int64         // no size rotation if 0
+	interval      time.Duration // no time rotation if 0
+	maxBackups    int           // all backups are kept if 0
+	compress      bool
+	fsync         string
+	fsyncInterval time.Duration
+
+	spans   chan model.SpanModel
+	dropped uint64
+	done    chan struct{}
+
+	// rotated files are compressed in the background, one at a time, along
+	// with removing the backups beyond the limit
+	compressing sync.WaitGroup
+	backupMu    sync.Mutex
+	backups     *regexp.Regexp
+
+	// owned by run
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+	synced time.Time
+	dirty  bool // written since the last sync
+}
+
+func newFileReporter(c *FileReporterConfig) *fileReporter {
+	r := &fileReporter{
+		path:          c.Path,
+		maxSize:       int64(c.MaxSizeMB) * 1024 * 1024,
+		interval:      time.Duration(c.RotationIntervalSeconds) * time.Second,
+		maxBackups:    c.MaxBackups,
+		compress:      c.Compress,
+		fsync:         c.Fsync,
+		fsyncInterval: defaultFsyncInterval,
+		done:          make(chan struct{}),
+		backups: regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(c.Path)) +
+			`\.\d{8}T\d{6}\.\d{3}(-\d+)?(\.gz)?$`),
+	}
+	if c.FsyncIntervalSeconds > 0 {
+		r.fsyncInterval = time.Duration(c.FsyncIntervalSeconds) * time.Second
+	}
+	backlog := defaultFileReporterBacklog
+	if c.MaxBacklog > 0 {
+		backlog = c.MaxBacklog
+	}
+	r.spans = make(chan model.SpanModel, backlog)
+	return r
+}
+
+// start starts writing spans, once the fields are set.
+func (r *fileReporter) start() *fileReporter {
+	go r.run()
+	return r
+}
+
+// Send implements reporter.Reporter.
+func (r *fileReporter) Send(s model.SpanModel) {
+	select {
+	case r.spans <- s:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+// Close implements reporter.Reporter, writing the pending spans and waiting
+// for the rotated files to be compressed.
+func (r *fileReporter) Close() error {
+	close(r.spans)
+	<-r.done
+	defer r.compressing.Wait()
+	if r.file == nil {
+		return nil
+	}
+	err := r.flush(r.fsync != FsyncNever)
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *fileReporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(fileReporterTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case s, ok := <-r.spans:
+			if !ok {
+				return
+			}
+			r.write(&s)
+			if len(r.spans) == 0 {
+				r.logErr(r.flush(r.fsync == FsyncAlways))
+			}
+		case now := <-ticker.C:
+			if r.file != nil && r.interval > 0 && now.Sub(r.opened) >= r.interval {
+				r.rotate()
+			}
+			if r.dirty && r.fsync == FsyncInterval && now.Sub(r.synced) >= r.fsyncInterval {
+				r.logErr(r.flush(true))
+			}
+			if n := atomic.SwapUint64(&r.dropped, 0); n > 0 {
+				log.Warnf("trpc-opentracing-zipkin: file reporter backlog full, %d spans dropped", n)
+			}
+		}
+	}
+}
+
+func (r *fileReporter) write(s *model.SpanModel) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to serialize span: %v", err)
+		return
+	}
+	b = append(b, '\n')
+	if r.file != nil && r.size > 0 &&
+		((r.maxSize > 0 && r.size+int64(len(b)) > r.maxSize) ||
+			(r.interval > 0 && time.Since(r.opened) >= r.interval)) {
+		r.rotate()
+	}
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			log.Errorf("trpc-opentracing-zipkin: failed to open span file: %v", err)
+			return
+		}
+	}
+	n, err := r.w.Write(b)
+	r.size += int64(n)
+	r.dirty = true
+	r.logErr(err)
+}
+
+func (r *fileReporter) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.w, r.size = f, bufio.NewWriter(f), st.Size()
+	r.opened, r.synced = time.Now(), time.Now()
+	return nil
+}
+
+// flush writes the buffered spans to the file, and syncs it if sync is set.
+func (r *fileReporter) flush(sync bool) error {
+	if r.file == nil {
+		return nil
+	}
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if !sync || !r.dirty {
+		return nil
+	}
+	r.dirty, r.synced = false, time.Now()
+	return r.file.Sync()
+}
+
+// rotate renames the current file to a backup, compresses it in the background
+// if needed, and removes the backups beyond the limit. The next write opens a
+// new file.
+func (r *fileReporter) rotate() {
+	r.logErr(r.flush(r.fsync != FsyncNever))
+	r.logErr(r.file.Close())
+	r.file, r.w = nil, nil
+	backup := r.backupName(time.Now())
+	if err := os.Rename(r.path, backup); err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to rotate span file: %v", err)
+		return
+	}
+	if !r.compress {
+		r.removeBackups()
+		return
+	}
+	r.compressing.Add(1)
+	go func() {
+		defer r.compressing.Done()
+		r.backupMu.Lock()
+		defer r.backupMu.Unlock()
+		// the backup may have been removed while waiting for earlier ones
+		if !fileExists(backup) {
+			return
+		}
+		if err := gzipFile(backup); err != nil {
+			log.Errorf("trpc-opentracing-zipkin: failed to compress span file: %v", err)
+		}
+		r.removeBackups()
+	}()
+}
+
+// backupName returns the name of the file rotated at t, which does not exist
+// yet, compressed or not.
+func (r *fileReporter) backupName(t time.Time) string {
+	name := r.path + "." + t.Format(backupTimeFormat)
+	backup := name
+	for seq := 1; fileExists(backup) || fileExists(backup+".gz"); seq++ {
+		backup = fmt.Sprintf("%s-%d", name, seq)
+	}
+	return backup
+}
+
+// removeBackups removes the oldest backups beyond maxBackups. Only the files
+// named as backups of the span file are counted.
+func (r *fileReporter) removeBackups() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	infos, err := ioutil.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to list span files: %v", err)
+		return
+	}
+	var backups []string
+	for _, info := range infos {
+		if !info.IsDir() && r.backups.MatchString(info.Name()) {
+			backups = append(backups, info.Name())
+		}
+	}
+	// a compressed backup sorts along with the uncompressed ones
+	sort.Slice(backups, func(i, j int) bool {
+		return strings.TrimSuffix(backups[i], ".gz") < strings.TrimSuffix(backups[j], ".gz")
+	})
+	for len(backups) > r.maxBackups {
+		r.logErr(os.Remove(filepath.Join(filepath.Dir(r.path), backups[0])))
+		backups = backups[1:]
+	}
+}
+
+func (r *fileReporter) logErr(err error) {
+	if err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to write span file %s: %v", r.path, err)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// gzipFile compresses path to path.gz and removes path.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,220 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// readSpanLines reads the spans of a JSON lines file, gzipped if it ends with .gz.
+func readSpanLines(t *testing.T, path string) []model.SpanModel {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var rd io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		assert.Nil(t, err)
+		rd = zr
+	}
+	var spans []model.SpanModel
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		var s model.SpanModel
+		assert.Nil(t, json.Unmarshal(sc.Bytes(), &s))
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+func fileTestSpan(id uint64) model.SpanModel {
+	return model.SpanModel{SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: model.ID(id)}, Name: "hello"}
+}
+
+// spanFiles returns the names of the files in dir, sorted.
+func spanFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileReporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_reporter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans", "zipkin.log")
+
+	rep, err := (&FileReporterConfig{Path: path, Fsync: FsyncAlways}).newReporter()
+	assert.Nil(t, err)
+	for i := uint64(1); i <= 3; i++ {
+		rep.Send(fileTestSpan(i))
+	}
+	assert.Nil(t, rep.Close())
+
+	spans := readSpanLines(t, path)
+	assert.Len(t, spans, 3)
+	for i, s := range spans {
+		assert.Equal(t, model.ID(i+1), s.ID)
+		assert.Equal(t, "hello", s.Name)
+	}
+}
+
+func TestFileReporter_Rotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{"plain", false},
+		{"gzip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "file_reporter")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "zipkin.log")
+
+			r := newFileReporter(&FileReporterConfig{Path: path, MaxBackups: 2, Compress: tt.compress})
+			// one span per file
+			line, err := json.Marshal(fileTestSpan(1))
+			assert.Nil(t, err)
+			r.maxSize = int64(len(line)) + 1
+			r.start()
+			for i := uint64(1); i <= 4; i++ {
+				r.Send(fileTestSpan(i))
+				// backups are named by the time of rotation
+				time.Sleep(5 * time.Millisecond)
+			}
+			assert.Nil(t, r.Close())
+
+			// the current file, then the backups oldest first, without the oldest one
+			names := spanFiles(t, dir)
+			assert.Len(t, names, 3)
+			wantIDs := []model.ID{4, 2, 3}
+			for i, name := range names {
+				if strings.HasSuffix(name, ".gz") != tt.compress && name != "zipkin.log" {
+					t.Errorf("unexpected backup %s", name)
+				}
+				spans := readSpanLines(t, filepath.Join(dir, name))
+				assert.Len(t, spans, 1)
+				assert.Equal(t, wantIDs[i], spans[0].ID)
+			}
+		})
+	}
+}
+
+func TestFileReporter_Backups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_reporter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "zipkin.log")
+	// files which are not backups of the span file are left alone
+	others := []string{"zipkin.log.old", "zipkin.log.20200101T000000.000.txt", "zipkin.log.1.gz"}
+	for _, name := range others {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	r := newFileReporter(&FileReporterConfig{Path: path, MaxBackups: 2, Compress: true})
+	line, err := json.Marshal(fileTestSpan(1))
+	assert.Nil(t, err)
+	r.maxSize = int64(len(line)) + 1
+	r.start()
+	// files rotated within the same millisecond do not overwrite each other
+	now := time.Now()
+	name := path + "." + now.Format(backupTimeFormat)
+	assert.Nil(t, ioutil.WriteFile(name+".gz", nil, 0644))
+	assert.Equal(t, name+"-1", r.backupName(now))
+	assert.Nil(t, os.Remove(name+".gz"))
+	for i := uint64(1); i <= 4; i++ {
+		r.Send(fileTestSpan(i))
+	}
+	assert.Nil(t, r.Close())
+
+	names := spanFiles(t, dir)
+	assert.Len(t, names, 3+len(others))
+	var ids []model.ID
+	for _, name := range names {
+		if r.backups.MatchString(name) {
+			assert.True(t, strings.HasSuffix(name, ".gz"))
+			for _, s := range readSpanLines(t, filepath.Join(dir, name)) {
+				ids = append(ids, s.ID)
+			}
+		}
+	}
+	assert.ElementsMatch(t, []model.ID{2, 3}, ids)
+	for _, name := range others {
+		assert.Contains(t, names, name)
+	}
+}
+
+func TestFileReporter_TimeRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_reporter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "zipkin.log")
+
+	r := newFileReporter(&FileReporterConfig{Path: path, Fsync: FsyncInterval})
+	r.interval = 20 * time.Millisecond
+	r.start()
+	r.Send(fileTestSpan(1))
+	time.Sleep(50 * time.Millisecond)
+	r.Send(fileTestSpan(2))
+	assert.Nil(t, r.Close())
+
+	names := spanFiles(t, dir)
+	assert.Len(t, names, 2)
+	assert.Equal(t, model.ID(2), readSpanLines(t, path)[0].ID)
+	assert.Equal(t, model.ID(1), readSpanLines(t, filepath.Join(dir, names[1]))[0].ID)
+}
+
+func TestFileReporter_Backlog(t *testing.T) {
+	r := newFileReporter(&FileReporterConfig{Path: "unused", MaxBacklog: 1})
+	// not started, the second span exceeds the backlog
+	r.Send(fileTestSpan(1))
+	r.Send(fileTestSpan(2))
+	assert.Len(t, r.spans, 1)
+	assert.Equal(t, uint64(1), r.dropped)
+}
+
+func TestConfig_ValidateFileReporter(t *testing.T) {
+	err := (&Config{
+		Sampler: &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: FileReporter, File: &FileReporterConfig{
+			MaxSizeMB: -1,
+			Fsync:     "sometimes",
+		}},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		"param [reporter.file.path] invalid: missing; "+
+		"param [reporter.file.max_size_mb] invalid: must not be negative; "+
+		`param [reporter.file.fsync] invalid: unknown fsync policy "sometimes"`)
+}
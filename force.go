@@ -0,0 +1,47 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"crypto/subtle"
+)
+
+// forceSampler forces the sampling of requests carrying its key.
+type forceSampler struct {
+	key    string
+	secret string
+}
+
+func newForceSampler(c *ForceSampleConfig) *forceSampler {
+	return &forceSampler{key: c.Key, secret: c.Secret}
+}
+
+// forced reports whether the request of carrier forces sampling.
+func (f *forceSampler) forced(carrier textMap) bool {
+	if f == nil {
+		return false
+	}
+	v := carrier.Get(f.key)
+	if v == "" {
+		return false
+	}
+	return f.secret == "" || subtle.ConstantTimeCompare([]byte(v), []byte(f.secret)) == 1
+}
+
+// force sets the debug flag on tc, which samples the whole downstream trace.
+// A tc without trace id starts a new trace with the flag.
+func force(tc *traceContext) {
+	tc.spanContext.Debug = true
+	tc.spanContext.Sampled = nil
+}
@@ -0,0 +1,121 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+func Test_forceSampler_forced(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler *forceSampler
+		md      metadataTextMap
+		want    bool
+	}{
+		{"disabled", nil, metadataTextMap{"x-force-trace": []byte("1")}, false},
+		{"absent", &forceSampler{key: "x-force-trace"}, metadataTextMap{}, false},
+		{"any value", &forceSampler{key: "x-force-trace"}, metadataTextMap{"x-force-trace": []byte("1")}, true},
+		{"secret", &forceSampler{key: "x-force-trace", secret: "s3cret"}, metadataTextMap{"x-force-trace": []byte("s3cret")}, true},
+		{"wrong secret", &forceSampler{key: "x-force-trace", secret: "s3cret"}, metadataTextMap{"x-force-trace": []byte("guess")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.sampler.forced(tt.md))
+		})
+	}
+
+	header := http.Header{}
+	header.Set("X-Force-Trace", "s3cret")
+	assert.True(t, (&forceSampler{key: "x-force-trace", secret: "s3cret"}).forced(httpHeaderTextMap(header)))
+}
+
+func TestFilter_ForceSample(t *testing.T) {
+	tests := []struct {
+		name      string
+		md        codec.MetaData
+		wantSpans int
+	}{
+		{"not forced", codec.MetaData{}, 0},
+		{"b3 flags", codec.MetaData{"x-b3-flags": []byte("1")}, 2},
+		{"b3 flags with trace", codec.MetaData{
+			"x-b3-traceid": []byte("4bf92f3577b34da6a3ce929d0e0e4736"),
+			"x-b3-spanid":  []byte("00f067aa0ba902b7"),
+			"x-b3-sampled": []byte("0"),
+			"x-b3-flags":   []byte("1"),
+		}, 2},
+		{"key", codec.MetaData{"x-force-trace": []byte("s3cret")}, 2},
+		{"wrong secret", codec.MetaData{"x-force-trace": []byte("guess")}, 0},
+		{"key overrides sampled", codec.MetaData{
+			"x-b3-traceid":  []byte("4bf92f3577b34da6a3ce929d0e0e4736"),
+			"x-b3-spanid":   []byte("00f067aa0ba902b7"),
+			"x-b3-sampled":  []byte("0"),
+			"x-force-trace": []byte("s3cret"),
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recorder.NewReporter()
+			c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: NeverSampler}}
+			tracer, err := c.newOpenTracingTracer(rec)
+			assert.Nil(t, err)
+			z := &zipkinPlugin{
+				tracers:      map[string]opentracing.Tracer{"trpc.app.server.Service": tracer},
+				forceSampler: newForceSampler(&ForceSampleConfig{Key: "x-force-trace", Secret: "s3cret"}),
+			}
+
+			ctx, msg := codec.WithNewMessage(context.Background())
+			msg.WithCalleeServiceName("trpc.app.server.Service")
+			msg.WithServerMetaData(tt.md)
+			var clientMD codec.MetaData
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+				clientMsg.WithCallerServiceName("trpc.app.server.Service")
+				err := ClientFilter(z)(clientCtx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+					clientMD = codec.Message(ctx).ClientMetaData()
+					return nil
+				})
+				return nil, err
+			}
+			_, err = ServerFilter(z)(ctx, nil, handler)
+			assert.Nil(t, err)
+
+			spans := rec.Flush()
+			assert.Len(t, spans, tt.wantSpans)
+			for _, span := range spans {
+				assert.True(t, span.Debug)
+			}
+			if tt.wantSpans > 0 {
+				// the debug flag is carried to the whole downstream trace
+				assert.Equal(t, "1", string(clientMD["x-b3-flags"]))
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateForceSample(t *testing.T) {
+	err := (&Config{
+		Sampler:     &SamplerConfig{Type: NeverSampler},
+		Reporter:    &ReporterConfig{Type: NoopReporter},
+		ForceSample: &ForceSampleConfig{Secret: "s3cret"},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: param [force_sample.key] invalid: missing")
+}
@@ -9,6 +9,8 @@ require (
 	github.com/openzipkin-contrib/zipkin-go-opentracing v0.4.4
 	github.com/openzipkin/zipkin-go v0.2.2
 	github.com/stretchr/testify v1.8.0
+	google.golang.org/grpc v1.22.1
+	google.golang.org/protobuf v1.30.0
 	gopkg.in/yaml.v3 v3.0.1
 	trpc.group/trpc-go/trpc-go v1.0.0
 )
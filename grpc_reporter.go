@@ -0,0 +1,208 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	zipkinProto "github.com/openzipkin/zipkin-go/proto/v2"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"google.golang.org/grpc"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+const (
+	// grpcReportMethod is the method of the zipkin grpc collector.
+	grpcReportMethod = "/zipkin.proto3.SpanService/Report"
+
+	// the defaults of the zipkin-go http reporter
+	defaultGRPCTimeout       = 5 * time.Second
+	defaultGRPCBatchInterval = time.Second
+	defaultGRPCBatchSize     = 100
+	defaultGRPCMaxBacklog    = 1000
+)
+
+// grpcReporter sends batches of spans as a proto3 ListOfSpans to the zipkin
+// grpc collector, in the same way as the zipkin-go http reporter.
+type grpcReporter struct {
+	conn          *grpc.ClientConn
+	serializer    reporter.SpanSerializer
+	timeout       time.Duration
+	batchInterval time.Duration
+	batchSize     int
+	maxBacklog    int
+
+	batchMu sync.Mutex
+	batch   []*model.SpanModel
+
+	spanC    chan *model.SpanModel
+	sendC    chan struct{}
+	quit     chan struct{}
+	shutdown chan error
+}
+
+func newGRPCReporter(conn *grpc.ClientConn, c *GRPCReporterConfig) *grpcReporter {
+	r := &grpcReporter{
+		conn:          conn,
+		serializer:    zipkinProto.SpanSerializer{},
+		timeout:       defaultGRPCTimeout,
+		batchInterval: defaultGRPCBatchInterval,
+		batchSize:     defaultGRPCBatchSize,
+		maxBacklog:    defaultGRPCMaxBacklog,
+		spanC:         make(chan *model.SpanModel),
+		sendC:         make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+		shutdown:      make(chan error, 1),
+	}
+	if c.TimeoutSeconds > 0 {
+		r.timeout = time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	if c.BatchIntervalSeconds > 0 {
+		r.batchInterval = time.Duration(c.BatchIntervalSeconds) * time.Second
+	}
+	if c.BatchSize > 0 {
+		r.batchSize = c.BatchSize
+	}
+	if c.MaxBacklog > 0 {
+		r.maxBacklog = c.MaxBacklog
+	}
+	go r.loop()
+	go r.sendLoop()
+	return r
+}
+
+// Send implements reporter.Reporter.
+func (r *grpcReporter) Send(s model.SpanModel) {
+	r.spanC <- &s
+}
+
+// Close implements reporter.Reporter, sending the pending spans.
+func (r *grpcReporter) Close() error {
+	close(r.quit)
+	err := <-r.shutdown
+	if cerr := r.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *grpcReporter) loop() {
+	nextSend := time.Now().Add(r.batchInterval)
+	ticker := time.NewTicker(r.batchInterval / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case span := <-r.spanC:
+			if r.append(span) >= r.batchSize {
+				nextSend = time.Now().Add(r.batchInterval)
+				r.enqueueSend()
+			}
+		case <-ticker.C:
+			if time.Now().After(nextSend) {
+				nextSend = time.Now().Add(r.batchInterval)
+				r.enqueueSend()
+			}
+		case <-r.quit:
+			close(r.sendC)
+			return
+		}
+	}
+}
+
+func (r *grpcReporter) sendLoop() {
+	for range r.sendC {
+		_ = r.sendBatch()
+	}
+	r.shutdown <- r.sendBatch()
+}
+
+func (r *grpcReporter) enqueueSend() {
+	select {
+	case r.sendC <- struct{}{}:
+	default:
+		// a send is already pending
+	}
+}
+
+// append appends span to the batch, disposing the oldest spans beyond the backlog.
+func (r *grpcReporter) append(span *model.SpanModel) int {
+	r.batchMu.Lock()
+	defer r.batchMu.Unlock()
+	r.batch = append(r.batch, span)
+	if dispose := len(r.batch) - r.maxBacklog; dispose > 0 {
+		log.Warnf("trpc-opentracing-zipkin: grpc reporter backlog too long, disposing %d spans", dispose)
+		r.batch = r.batch[dispose:]
+	}
+	return len(r.batch)
+}
+
+func (r *grpcReporter) sendBatch() error {
+	r.batchMu.Lock()
+	batch := r.batch[:]
+	r.batchMu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// sent spans are removed from the batch even if they were not saved
+	defer func() {
+		r.batchMu.Lock()
+		r.batch = r.batch[len(batch):]
+		r.batchMu.Unlock()
+	}()
+	body, err := r.serializer.Serialize(batch)
+	if err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to serialize spans: %v", err)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	var rsp []byte
+	if err := r.conn.Invoke(ctx, grpcReportMethod, body, &rsp, grpc.ForceCodec(rawCodec{})); err != nil {
+		log.Errorf("trpc-opentracing-zipkin: failed to report %d spans over grpc: %v", len(batch), err)
+		return err
+	}
+	return nil
+}
+
+// rawCodec passes messages already encoded as bytes.
+type rawCodec struct{}
+
+// Marshal implements encoding.Codec.
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return b, nil
+}
+
+// Unmarshal implements encoding.Codec.
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// Name implements encoding.Codec.
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,142 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go/model"
+	zipkinProto "github.com/openzipkin/zipkin-go/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcTestCodec is the server side of rawCodec.
+type grpcTestCodec struct {
+	rawCodec
+}
+
+func (grpcTestCodec) String() string { return "proto" }
+
+// startGRPCCollector starts a zipkin grpc collector stub sending each received
+// batch to the returned channel.
+func startGRPCCollector(t *testing.T, opts ...grpc.ServerOption) (string, <-chan []*model.SpanModel, func()) {
+	received := make(chan []*model.SpanModel, 10)
+	srv := grpc.NewServer(append(opts, grpc.CustomCodec(grpcTestCodec{}))...)
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "zipkin.proto3.SpanService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Report",
+			Handler: func(_ interface{}, _ context.Context, dec func(interface{}) error,
+				_ grpc.UnaryServerInterceptor) (interface{}, error) {
+				var b []byte
+				if err := dec(&b); err != nil {
+					return nil, err
+				}
+				spans, err := zipkinProto.ParseSpans(b, false)
+				assert.Nil(t, err)
+				received <- spans
+				return []byte{}, nil
+			},
+		}},
+	}, struct{}{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = srv.Serve(lis) }()
+	return lis.Addr().String(), received, srv.Stop
+}
+
+func TestGRPCReporter(t *testing.T) {
+	addr, received, stop := startGRPCCollector(t)
+	defer stop()
+
+	rep, err := (&GRPCReporterConfig{Address: addr, BatchSize: 2, BatchIntervalSeconds: 60}).newReporter()
+	assert.Nil(t, err)
+	span := func(id uint64) model.SpanModel {
+		return model.SpanModel{SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: model.ID(id)}}
+	}
+	// a full batch is sent at once, and the rest on close
+	rep.Send(span(1))
+	rep.Send(span(2))
+	assert.Len(t, <-received, 2)
+	rep.Send(span(3))
+	assert.Nil(t, rep.Close())
+	spans := <-received
+	assert.Len(t, spans, 1)
+	assert.Equal(t, model.ID(3), spans[0].ID)
+}
+
+func TestGRPCReporter_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	cert := ts.TLS.Certificates[0]
+	addr, received, stop := startGRPCCollector(t, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "grpc_reporter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	assert.Nil(t, ioutil.WriteFile(caFile, caPEM, 0644))
+
+	rep, err := (&GRPCReporterConfig{
+		Address: addr,
+		TLS:     &TLSConfig{CAFile: caFile, ServerName: "example.com"},
+	}).newReporter()
+	assert.Nil(t, err)
+	rep.Send(model.SpanModel{SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: 1}, Name: "hello"})
+	assert.Nil(t, rep.Close())
+	spans := <-received
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "hello", spans[0].Name)
+
+	_, err = (&GRPCReporterConfig{Address: addr, TLS: &TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}}).newReporter()
+	assert.NotNil(t, err)
+}
+
+func TestGRPCReporter_Backlog(t *testing.T) {
+	// the collector is unreachable, spans beyond the backlog are disposed
+	r := newGRPCReporter(nil, &GRPCReporterConfig{MaxBacklog: 2})
+	close(r.quit)
+	<-r.shutdown
+	for i := 1; i <= 3; i++ {
+		r.append(&model.SpanModel{SpanContext: model.SpanContext{ID: model.ID(i)}})
+	}
+	assert.Len(t, r.batch, 2)
+	assert.Equal(t, model.ID(2), r.batch[0].ID)
+}
+
+func TestConfig_ValidateGRPCReporter(t *testing.T) {
+	err := (&Config{
+		Sampler: &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: GRPCReporter, GRPC: &GRPCReporterConfig{
+			TimeoutSeconds: -1,
+			TLS:            &TLSConfig{CertFile: "client.pem"},
+		}},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		"param [reporter.grpc.address] invalid: missing; "+
+		"param [reporter.grpc.time_out_seconds] invalid: must not be negative; "+
+		"param [reporter.grpc.tls.cert_file] invalid: cert_file and key_file should be set together")
+}
@@ -0,0 +1,56 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"github.com/opentracing/opentracing-go"
+)
+
+// Ways of using the trace context of incoming requests.
+const (
+	// TrustContext continues the incoming trace, with its sampling decision and baggage.
+	TrustContext = "trust"
+	// IgnoreContext drops the incoming context, the request starts a new trace.
+	IgnoreContext = "ignore"
+	// LinkContext starts a new trace like IgnoreContext, with the incoming trace
+	// and span ids tagged on the server span.
+	LinkContext = "link"
+)
+
+// Tags linking a server span to the untrusted context of its request.
+const (
+	TagExternalTraceID = "trpc.external_trace_id"
+	TagExternalSpanID  = "trpc.external_span_id"
+)
+
+// untrusted returns an empty trace context in place of the extracted tc,
+// keeping the extracted span context as the external one if link is set.
+func untrusted(tc *traceContext, found bool, link bool) (*traceContext, bool) {
+	c := &traceContext{}
+	// the debug flag alone carries no trace to link to
+	if link && found && !tc.spanContext.TraceID.Empty() {
+		sc := tc.spanContext
+		c.external = &sc
+	}
+	return c, false
+}
+
+// tagExternal tags span with the external context of tc, if any.
+func tagExternal(span opentracing.Span, tc *traceContext) {
+	if tc == nil || tc.external == nil {
+		return
+	}
+	span.SetTag(TagExternalTraceID, tc.external.TraceID.String())
+	span.SetTag(TagExternalSpanID, tc.external.ID.String())
+}
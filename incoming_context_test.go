@@ -0,0 +1,105 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+	trpcHTTP "trpc.group/trpc-go/trpc-go/http"
+)
+
+func TestServerFilter_TrustIncomingContext(t *testing.T) {
+	const externalTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		trust       string
+		headers     map[string]string
+		wantSpans   int
+		wantTraceID bool
+		wantLinked  bool
+	}{
+		{"trust", TrustContext, map[string]string{
+			"X-B3-TraceId": externalTraceID, "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Sampled": "1",
+		}, 1, true, false},
+		{"ignore", IgnoreContext, map[string]string{
+			"X-B3-TraceId": externalTraceID, "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Sampled": "1",
+		}, 0, false, false},
+		{"ignore debug", IgnoreContext, map[string]string{"X-B3-Flags": "1"}, 0, false, false},
+		{"ignore forced by key", IgnoreContext, map[string]string{"X-Force-Trace": "s3cret"}, 1, false, false},
+		{"link", LinkContext, map[string]string{
+			"X-B3-TraceId": externalTraceID, "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Flags": "1",
+		}, 0, false, true},
+		{"link forced by key", LinkContext, map[string]string{
+			"X-B3-TraceId": externalTraceID, "X-B3-SpanId": "00f067aa0ba902b7", "X-Force-Trace": "s3cret",
+		}, 1, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recorder.NewReporter()
+			c := &Config{ServiceName: "trpc.app.server.Public", Sampler: &SamplerConfig{Type: NeverSampler}}
+			tracer, err := c.newOpenTracingTracer(rec)
+			assert.Nil(t, err)
+			z := &zipkinPlugin{
+				tracers:          map[string]opentracing.Tracer{"trpc.app.server.Public": tracer},
+				forceSampler:     newForceSampler(&ForceSampleConfig{Key: "x-force-trace", Secret: "s3cret"}),
+				incomingContexts: map[string]string{"trpc.app.server.Public": tt.trust},
+			}
+
+			req := httptest.NewRequest("GET", "/hello", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			ctx := context.WithValue(context.Background(), trpcHTTP.ContextKeyHeader, &trpcHTTP.Header{Request: req})
+			ctx, msg := codec.WithNewMessage(ctx)
+			msg.WithCalleeServiceName("trpc.app.server.Public")
+			var tc *traceContext
+			_, err = ServerFilter(z)(ctx, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+				tc = traceContextFromContext(ctx)
+				return nil, nil
+			})
+			assert.Nil(t, err)
+
+			assert.Equal(t, tt.wantTraceID, tc.spanContext.TraceID.String() == externalTraceID)
+			spans := rec.Flush()
+			assert.Len(t, spans, tt.wantSpans)
+			for _, span := range spans {
+				assert.Equal(t, tt.wantTraceID, span.TraceID.String() == externalTraceID)
+				if tt.wantLinked {
+					assert.Nil(t, span.ParentID)
+					assert.Equal(t, externalTraceID, span.Tags[TagExternalTraceID])
+					assert.Equal(t, "00f067aa0ba902b7", span.Tags[TagExternalSpanID])
+				} else {
+					assert.NotContains(t, span.Tags, TagExternalTraceID)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateTrustIncomingContext(t *testing.T) {
+	err := (&Config{
+		Sampler:              &SamplerConfig{Type: AlwaysSampler},
+		Reporter:             &ReporterConfig{Type: NoopReporter},
+		TrustIncomingContext: LinkContext,
+		Services:             map[string]*ServiceConfig{"a": {TrustIncomingContext: "never"}},
+	}).Validate()
+	assert.EqualError(t, err,
+		`trpc-opentracing-zipkin: invalid config: param [services.a.trust_incoming_context] invalid: unknown value "never"`)
+}
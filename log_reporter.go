@@ -0,0 +1,184 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// Formats of the log reporter.
+const (
+	// CompactLogFormat logs each span on one line.
+	CompactLogFormat = "compact"
+	// TreeLogFormat logs the spans of each local trace as a tree.
+	TreeLogFormat = "tree"
+
+	// maxLogTraces bounds the traces buffered by the tree format, beyond
+	// which the oldest trace is logged as is.
+	maxLogTraces = 1000
+)
+
+// logReporter logs spans through a trpc logger, for local development.
+type logReporter struct {
+	loggerName string
+	level      string
+	tree       bool
+
+	mu     sync.Mutex
+	traces map[model.TraceID]*list.Element // of []model.SpanModel
+	order  *list.List
+}
+
+func newLogReporter(c *LogReporterConfig) *logReporter {
+	level := c.Level
+	if level == "" {
+		level = "info"
+	}
+	return &logReporter{
+		loggerName: c.Logger,
+		level:      level,
+		tree:       c.Format == TreeLogFormat,
+		traces:     make(map[model.TraceID]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Send implements reporter.Reporter.
+func (r *logReporter) Send(s model.SpanModel) {
+	if !r.tree {
+		r.log("zipkin span: %s", compactSpan(&s))
+		return
+	}
+	r.mu.Lock()
+	e, ok := r.traces[s.TraceID]
+	if !ok {
+		e = r.order.PushBack([]model.SpanModel(nil))
+		r.traces[s.TraceID] = e
+	}
+	e.Value = append(e.Value.([]model.SpanModel), s)
+	var ready [][]model.SpanModel
+	if localRoot(&s) {
+		ready = append(ready, r.removeLocked(e))
+	}
+	if r.order.Len() > maxLogTraces {
+		ready = append(ready, r.removeLocked(r.order.Front()))
+	}
+	r.mu.Unlock()
+	for _, spans := range ready {
+		r.log("zipkin trace %s\n%s", spans[0].TraceID, spanTree(spans))
+	}
+}
+
+// Close implements reporter.Reporter, logging the buffered traces.
+func (r *logReporter) Close() error {
+	r.mu.Lock()
+	var ready [][]model.SpanModel
+	for r.order.Len() > 0 {
+		ready = append(ready, r.removeLocked(r.order.Front()))
+	}
+	r.mu.Unlock()
+	for _, spans := range ready {
+		r.log("zipkin trace %s\n%s", spans[0].TraceID, spanTree(spans))
+	}
+	return nil
+}
+
+func (r *logReporter) removeLocked(e *list.Element) []model.SpanModel {
+	spans := r.order.Remove(e).([]model.SpanModel)
+	delete(r.traces, spans[0].TraceID)
+	return spans
+}
+
+// log logs at the configured level with the configured logger, which is
+// looked up on each call as loggers may be set up after the reporter.
+func (r *logReporter) log(format string, args ...interface{}) {
+	logger := log.GetDefaultLogger()
+	if r.loggerName != "" {
+		if l := log.Get(r.loggerName); l != nil {
+			logger = l
+		}
+	}
+	switch r.level {
+	case "trace":
+		logger.Tracef(format, args...)
+	case "debug":
+		logger.Debugf(format, args...)
+	case "warn":
+		logger.Warnf(format, args...)
+	case "error":
+		logger.Errorf(format, args...)
+	default:
+		logger.Infof(format, args...)
+	}
+}
+
+// compactSpan describes s on one line.
+func compactSpan(s *model.SpanModel) string {
+	parent := "-"
+	if s.ParentID != nil {
+		parent = s.ParentID.String()
+	}
+	line := fmt.Sprintf("trace=%s span=%s parent=%s name=%s kind=%s duration=%s",
+		s.TraceID, s.ID, parent, s.Name, s.Kind, s.Duration)
+	if v, ok := s.Tags["error"]; ok {
+		line += " error=" + v
+	}
+	return line
+}
+
+// spanTree describes spans of a trace as a tree, a line per span indented
+// under its parent. Spans whose parent is not in spans are roots.
+func spanTree(spans []model.SpanModel) string {
+	ids := make(map[model.ID]bool, len(spans))
+	for i := range spans {
+		ids[spans[i].ID] = true
+	}
+	children := make(map[model.ID][]*model.SpanModel)
+	var roots []*model.SpanModel
+	for i := range spans {
+		s := &spans[i]
+		if s.ParentID != nil && ids[*s.ParentID] && *s.ParentID != s.ID {
+			children[*s.ParentID] = append(children[*s.ParentID], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+
+	var b strings.Builder
+	var walk func(ss []*model.SpanModel, depth int)
+	walk = func(ss []*model.SpanModel, depth int) {
+		sort.SliceStable(ss, func(i, j int) bool { return ss[i].Timestamp.Before(ss[j].Timestamp) })
+		for _, s := range ss {
+			fmt.Fprintf(&b, "%s- %s", strings.Repeat("  ", depth), s.Name)
+			if s.Kind != model.Undetermined {
+				b.WriteString(" " + string(s.Kind))
+			}
+			fmt.Fprintf(&b, " %s span=%s", s.Duration, s.ID)
+			if v, ok := s.Tags["error"]; ok {
+				b.WriteString(" error=" + v)
+			}
+			b.WriteByte('\n')
+			walk(children[s.ID], depth+1)
+		}
+	}
+	walk(roots, 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,128 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// captureLogger records the lines logged through the methods of the log
+// reporter.
+type captureLogger struct {
+	log.Logger
+	lines []string
+}
+
+func (l *captureLogger) Tracef(format string, args ...interface{}) { l.add("trace", format, args) }
+func (l *captureLogger) Debugf(format string, args ...interface{}) { l.add("debug", format, args) }
+func (l *captureLogger) Infof(format string, args ...interface{})  { l.add("info", format, args) }
+func (l *captureLogger) Warnf(format string, args ...interface{})  { l.add("warn", format, args) }
+func (l *captureLogger) Errorf(format string, args ...interface{}) { l.add("error", format, args) }
+
+func (l *captureLogger) add(level, format string, args []interface{}) {
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func newLogTestSpan(id, parent uint64, kind model.Kind, name string, start time.Time) model.SpanModel {
+	s := model.SpanModel{
+		SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: model.ID(id)},
+		Name:        name,
+		Kind:        kind,
+		Timestamp:   start,
+		Duration:    3 * time.Millisecond,
+	}
+	if parent != 0 {
+		p := model.ID(parent)
+		s.ParentID = &p
+	}
+	return s
+}
+
+func TestLogReporter(t *testing.T) {
+	logger := &captureLogger{}
+	log.Register("zipkin_log_reporter_test", logger)
+
+	now := time.Now()
+	server := newLogTestSpan(1, 0, model.Server, "/trpc.app.server.Service/Hello", now)
+	first := newLogTestSpan(2, 1, model.Client, "/trpc.app.backend.Service/Get", now.Add(time.Millisecond))
+	first.Tags = map[string]string{"error": "true"}
+	second := newLogTestSpan(3, 1, model.Client, "/trpc.app.backend.Service/Put", now.Add(2*time.Millisecond))
+	local := newLogTestSpan(4, 3, model.Undetermined, "encode", now.Add(2*time.Millisecond))
+
+	t.Run("compact", func(t *testing.T) {
+		logger.lines = nil
+		r, err := (&Config{Reporter: &ReporterConfig{Type: LogReporter, Log: &LogReporterConfig{
+			Level:  "debug",
+			Logger: "zipkin_log_reporter_test",
+		}}}).newReporter()
+		assert.Nil(t, err)
+		r.Send(first)
+		r.Send(server)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, []string{
+			"debug zipkin span: trace=0000000000000001 span=0000000000000002 parent=0000000000000001 " +
+				"name=/trpc.app.backend.Service/Get kind=CLIENT duration=3ms error=true",
+			"debug zipkin span: trace=0000000000000001 span=0000000000000001 parent=- " +
+				"name=/trpc.app.server.Service/Hello kind=SERVER duration=3ms",
+		}, logger.lines)
+	})
+
+	t.Run("tree", func(t *testing.T) {
+		logger.lines = nil
+		r := newLogReporter(&LogReporterConfig{Format: TreeLogFormat, Logger: "zipkin_log_reporter_test"})
+		r.Send(local)
+		r.Send(second)
+		r.Send(first)
+		assert.Empty(t, logger.lines)
+		r.Send(server)
+		assert.Equal(t, []string{"info zipkin trace 0000000000000001\n" +
+			"- /trpc.app.server.Service/Hello SERVER 3ms span=0000000000000001\n" +
+			"  - /trpc.app.backend.Service/Get CLIENT 3ms span=0000000000000002 error=true\n" +
+			"  - /trpc.app.backend.Service/Put CLIENT 3ms span=0000000000000003\n" +
+			"    - encode 3ms span=0000000000000004",
+		}, logger.lines)
+
+		// traces without their local root are logged on close
+		logger.lines = nil
+		r.Send(local)
+		assert.Empty(t, logger.lines)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, []string{"info zipkin trace 0000000000000001\n- encode 3ms span=0000000000000004"}, logger.lines)
+	})
+}
+
+func TestConfig_ValidateLogReporter(t *testing.T) {
+	err := (&Config{
+		Sampler:  &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: LogReporter},
+	}).Validate()
+	assert.Nil(t, err)
+
+	err = (&Config{
+		Sampler: &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: LogReporter, Log: &LogReporterConfig{
+			Format: "json",
+			Level:  "fatal",
+		}},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		`param [reporter.log.format] invalid: unknown format "json"; `+
+		`param [reporter.log.level] invalid: unknown level "fatal"`)
+}
@@ -0,0 +1,106 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"net"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+// Policies on the sampling decision of the upstream.
+const (
+	RespectParent          = "respect_parent"
+	IgnoreParent           = "ignore_parent"
+	RespectParentIfTrusted = "respect_parent_if_trusted"
+)
+
+// parentPolicy decides whether the sampling decision of the upstream is kept,
+// nil respects every upstream.
+type parentPolicy struct {
+	policy         string
+	trustedCallers map[string]bool
+	trustedNets    []*net.IPNet
+}
+
+// newParentPolicy news the policy of c, which must have been validated.
+func newParentPolicy(c *ParentPolicyConfig) *parentPolicy {
+	if c == nil || c.Policy == "" || c.Policy == RespectParent {
+		return nil
+	}
+	p := &parentPolicy{policy: c.Policy, trustedCallers: make(map[string]bool, len(c.TrustedCallers))}
+	for _, caller := range c.TrustedCallers {
+		p.trustedCallers[caller] = true
+	}
+	for _, cidr := range c.TrustedCIDRs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			p.trustedNets = append(p.trustedNets, ipNet)
+		}
+	}
+	return p
+}
+
+// respects tells whether the sampling decision carried by msg is kept. The caller
+// service name is set by the caller itself, so when both trusted callers and
+// networks are configured the caller must match both.
+func (p *parentPolicy) respects(msg codec.Msg) bool {
+	if p == nil {
+		return true
+	}
+	if p.policy == IgnoreParent {
+		return false
+	}
+	if len(p.trustedCallers) > 0 && !p.trustedCallers[msg.CallerServiceName()] {
+		return false
+	}
+	if len(p.trustedNets) == 0 {
+		return true
+	}
+	ip := addrIP(msg.RemoteAddr())
+	for _, n := range p.trustedNets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreDecision clears the sampling decision of tc, keeping the trace, so that
+// it is decided by the local sampler.
+func ignoreDecision(tc *traceContext) {
+	tc.spanContext.Sampled = nil
+	tc.spanContext.Debug = false
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case nil:
+		return nil
+	case *net.TCPAddr:
+		if a == nil {
+			return nil
+		}
+		return a.IP
+	case *net.UDPAddr:
+		if a == nil {
+			return nil
+		}
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
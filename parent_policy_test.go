@@ -0,0 +1,144 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+func Test_parentPolicy_respects(t *testing.T) {
+	callers := &ParentPolicyConfig{
+		Policy:         RespectParentIfTrusted,
+		TrustedCallers: []string{"trpc.app.gateway.Service"},
+	}
+	cidrs := &ParentPolicyConfig{
+		Policy:       RespectParentIfTrusted,
+		TrustedCIDRs: []string{"10.0.0.0/8", "fd00::/8"},
+	}
+	both := &ParentPolicyConfig{
+		Policy:         RespectParentIfTrusted,
+		TrustedCallers: []string{"trpc.app.gateway.Service"},
+		TrustedCIDRs:   []string{"10.0.0.0/8"},
+	}
+	tests := []struct {
+		name   string
+		cfg    *ParentPolicyConfig
+		caller string
+		addr   net.Addr
+		want   bool
+	}{
+		{"default", nil, "", nil, true},
+		{"respect", &ParentPolicyConfig{Policy: RespectParent}, "", nil, true},
+		{"ignore", &ParentPolicyConfig{Policy: IgnoreParent}, "trpc.app.gateway.Service", nil, false},
+		{"trusted caller", callers, "trpc.app.gateway.Service", nil, true},
+		{"untrusted caller", callers, "trpc.app.client.Service", nil, false},
+		{"trusted tcp addr", cidrs, "", &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 80}, true},
+		{"trusted udp addr", cidrs, "", &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 80}, true},
+		{"trusted unix addr", cidrs, "", &net.UnixAddr{Name: "10.1.2.3:80", Net: "unix"}, true},
+		{"untrusted addr", cidrs, "", &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 80}, false},
+		{"nil tcp addr", cidrs, "", (*net.TCPAddr)(nil), false},
+		{"trusted caller and addr", both, "trpc.app.gateway.Service", &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, true},
+		{"spoofed caller", both, "trpc.app.gateway.Service", &net.TCPAddr{IP: net.ParseIP("203.0.113.1")}, false},
+		{"untrusted caller in trusted net", both, "trpc.app.client.Service", &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := codec.Message(context.Background())
+			msg.WithCallerServiceName(tt.caller)
+			msg.WithRemoteAddr(tt.addr)
+			assert.Equal(t, tt.want, newParentPolicy(tt.cfg).respects(msg))
+		})
+	}
+}
+
+func TestServerFilter_ParentPolicy(t *testing.T) {
+	trusted := &ParentPolicyConfig{Policy: RespectParentIfTrusted, TrustedCIDRs: []string{"10.0.0.0/8"}}
+	tests := []struct {
+		name      string
+		cfg       *ParentPolicyConfig
+		sampler   string
+		sampled   string
+		addr      string
+		wantSpans int
+	}{
+		{"respect sampled", nil, NeverSampler, "1", "203.0.113.1", 1},
+		{"ignore sampled", &ParentPolicyConfig{Policy: IgnoreParent}, NeverSampler, "1", "203.0.113.1", 0},
+		{"ignore unsampled", &ParentPolicyConfig{Policy: IgnoreParent}, AlwaysSampler, "0", "203.0.113.1", 1},
+		{"trusted", trusted, NeverSampler, "1", "10.0.0.1", 1},
+		{"untrusted", trusted, NeverSampler, "1", "203.0.113.1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recorder.NewReporter()
+			c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: tt.sampler}}
+			tracer, err := c.newOpenTracingTracer(rec)
+			assert.Nil(t, err)
+			z := &zipkinPlugin{
+				tracers:        map[string]opentracing.Tracer{"trpc.app.server.Service": tracer},
+				parentPolicies: map[string]*parentPolicy{"": newParentPolicy(tt.cfg)},
+			}
+
+			ctx, msg := codec.WithNewMessage(context.Background())
+			msg.WithCalleeServiceName("trpc.app.server.Service")
+			msg.WithRemoteAddr(&net.TCPAddr{IP: net.ParseIP(tt.addr), Port: 8000})
+			msg.WithServerMetaData(codec.MetaData{
+				"x-b3-traceid": []byte("4bf92f3577b34da6a3ce929d0e0e4736"),
+				"x-b3-spanid":  []byte("00f067aa0ba902b7"),
+				"x-b3-sampled": []byte(tt.sampled),
+			})
+			_, err = ServerFilter(z)(ctx, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			assert.Nil(t, err)
+
+			spans := rec.Flush()
+			assert.Len(t, spans, tt.wantSpans)
+			for _, span := range spans {
+				// the trace is kept even when its sampling decision is not
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String())
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateParentPolicy(t *testing.T) {
+	err := (&Config{
+		Sampler:      &SamplerConfig{Type: AlwaysSampler},
+		Reporter:     &ReporterConfig{Type: NoopReporter},
+		ParentPolicy: &ParentPolicyConfig{Policy: RespectParentIfTrusted, TrustedCIDRs: []string{"10.0.0.0/8", "10.0.0.1"}},
+		Services: map[string]*ServiceConfig{
+			"a": {ParentPolicy: &ParentPolicyConfig{Policy: RespectParentIfTrusted}},
+			"b": {ParentPolicy: &ParentPolicyConfig{Policy: "trust_all"}},
+		},
+	}).Validate()
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	var fields []string
+	for _, fe := range errs {
+		fields = append(fields, fe.Field)
+	}
+	assert.Equal(t, []string{
+		"parent_policy.trusted_cidrs[1]",
+		"services.a.parent_policy.trusted_callers",
+		"services.b.parent_policy.policy",
+	}, fields)
+}
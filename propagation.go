@@ -0,0 +1,571 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+)
+
+// Propagation formats.
+const (
+	// B3Propagation is the B3 multi-header format, such as X-B3-TraceId.
+	B3Propagation = "b3"
+	// B3SinglePropagation is the B3 single-header format: b3: {traceid}-{spanid}-{sampled}.
+	B3SinglePropagation = "b3single"
+	// W3CPropagation is the W3C Trace Context format: traceparent and tracestate.
+	W3CPropagation = "w3c"
+	// JaegerPropagation is the Jaeger format: uber-trace-id and uberctx-{key} baggage.
+	JaegerPropagation = "jaeger"
+)
+
+// B3 inject styles, they tell which B3 headers are injected by the b3 format.
+const (
+	// B3InjectMulti injects the multi headers only.
+	B3InjectMulti = "multi"
+	// B3InjectSingle injects the single header only.
+	B3InjectSingle = "single"
+	// B3InjectBoth injects both the single and the multi headers.
+	B3InjectBoth = "both"
+)
+
+const (
+	traceParentHeader   = "traceparent"
+	traceStateHeader    = "tracestate"
+	jaegerHeader        = "uber-trace-id"
+	jaegerBaggagePrefix = "uberctx-"
+)
+
+var (
+	errInvalidTraceParent = errors.New("invalid traceparent header")
+	errInvalidJaegerTrace = errors.New("invalid uber-trace-id header")
+)
+
+// textMap is the carrier of trace context, it is implemented by trpc metadata and http headers.
+type textMap interface {
+	Get(key string) string
+	Set(key, val string)
+	ForeachKey(callback func(key, val string) error) error
+}
+
+// httpHeaderTextMap implements textMap for http headers.
+type httpHeaderTextMap http.Header
+
+// Get implements textMap.
+func (h httpHeaderTextMap) Get(key string) string {
+	return http.Header(h).Get(key)
+}
+
+// Set implements textMap.
+func (h httpHeaderTextMap) Set(key, val string) {
+	http.Header(h).Set(key, val)
+}
+
+// ForeachKey implements textMap.
+func (h httpHeaderTextMap) ForeachKey(callback func(key, val string) error) error {
+	for k, vs := range h {
+		for _, v := range vs {
+			if err := callback(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// traceContext is the trace information carried across a call.
+type traceContext struct {
+	spanContext model.SpanContext
+	// traceState is the W3C tracestate header, which is forwarded as is.
+	traceState string
+
+	// mu guards baggage, which may be updated through the server span.
+	mu sync.RWMutex
+	// baggage holds the baggage items carried along the trace.
+	baggage map[string]string
+	// external is the untrusted context of the incoming request, which is only
+	// linked to.
+	external *model.SpanContext
+}
+
+// child returns the trace context to propagate to a downstream call with span context sc.
+func (tc *traceContext) child(sc model.SpanContext) *traceContext {
+	c := &traceContext{spanContext: sc}
+	if tc == nil {
+		return c
+	}
+	c.traceState = tc.traceState
+	c.baggage = tc.baggageItems()
+	return c
+}
+
+// baggageItem returns the baggage item of key.
+func (tc *traceContext) baggageItem(key string) string {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.baggage[key]
+}
+
+// setBaggageItem sets the baggage item of key.
+func (tc *traceContext) setBaggageItem(key, val string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.baggage == nil {
+		tc.baggage = make(map[string]string)
+	}
+	tc.baggage[key] = val
+}
+
+// baggageItems returns a copy of the baggage items.
+func (tc *traceContext) baggageItems() map[string]string {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	if len(tc.baggage) == 0 {
+		return nil
+	}
+	items := make(map[string]string, len(tc.baggage))
+	for k, v := range tc.baggage {
+		items[k] = v
+	}
+	return items
+}
+
+type traceContextKey struct{}
+
+// contextWithTraceContext returns a copy of ctx holding tc.
+func contextWithTraceContext(ctx context.Context, tc *traceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// traceContextFromContext returns the trace context extracted by the server filter.
+func traceContextFromContext(ctx context.Context) *traceContext {
+	tc, _ := ctx.Value(traceContextKey{}).(*traceContext)
+	return tc
+}
+
+// propagator injects and extracts trace context in a propagation format.
+type propagator interface {
+	// inject writes tc into carrier.
+	inject(tc *traceContext, carrier textMap)
+	// extract reads the trace context in carrier into tc, it reports whether
+	// a span context is found.
+	extract(carrier textMap, tc *traceContext) (bool, error)
+	// owns tells whether the lower cased key is written by the format.
+	owns(key string) bool
+}
+
+// baggageExtractor is implemented by the formats carrying baggage. Their baggage is
+// extracted even if the span context is taken from another format.
+type baggageExtractor interface {
+	// extractBaggage adds the baggage items in carrier into tc.
+	extractBaggage(carrier textMap, tc *traceContext)
+}
+
+// propagators injects all configured formats and extracts the first one found.
+type propagators []propagator
+
+// newPropagators returns the propagators of the formats, B3 is used if no format is given.
+// b3InjectStyle selects the headers injected by the b3 format, defaults to multi headers.
+func newPropagators(formats []string, b3InjectStyle string) (propagators, error) {
+	if len(formats) == 0 {
+		formats = []string{B3Propagation}
+	}
+	ps := make(propagators, 0, len(formats))
+	for _, f := range formats {
+		p, err := newPropagator(f, b3InjectStyle)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+func newPropagator(format, b3InjectStyle string) (propagator, error) {
+	switch format {
+	case B3Propagation:
+		opts, err := b3InjectOptions(b3InjectStyle)
+		if err != nil {
+			return nil, err
+		}
+		return b3Propagator{opts: opts}, nil
+	case B3SinglePropagation:
+		return b3Propagator{opts: []b3.InjectOption{b3.WithSingleHeaderOnly()}}, nil
+	case W3CPropagation:
+		return w3cPropagator{}, nil
+	case JaegerPropagation:
+		return jaegerPropagator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown propagation format %q", format)
+	}
+}
+
+func b3InjectOptions(style string) ([]b3.InjectOption, error) {
+	switch style {
+	case "", B3InjectMulti:
+		return nil, nil
+	case B3InjectSingle:
+		return []b3.InjectOption{b3.WithSingleHeaderOnly()}, nil
+	case B3InjectBoth:
+		return []b3.InjectOption{b3.WithSingleAndMultiHeader()}, nil
+	default:
+		return nil, fmt.Errorf("unknown b3 inject style %q", style)
+	}
+}
+
+// inject writes tc into carrier in every format. The keys of these formats
+// forwarded by trpc from the upstream metadata are removed first, so that
+// downstream services do not take them for the context of the call.
+func (ps propagators) inject(tc *traceContext, carrier textMap) {
+	if md, ok := carrier.(metadataTextMap); ok {
+		for k := range md {
+			if ps.owns(strings.ToLower(k)) {
+				delete(md, k)
+			}
+		}
+	}
+	for _, p := range ps {
+		p.inject(tc, carrier)
+	}
+}
+
+func (ps propagators) owns(key string) bool {
+	for _, p := range ps {
+		if p.owns(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// extract returns the trace context of the first format found in carrier, with the
+// baggage of all formats. The errors of formats which are present but malformed are
+// returned as well.
+func (ps propagators) extract(carrier textMap) (*traceContext, bool, []error) {
+	var (
+		errs  []error
+		found bool
+		tc    = &traceContext{}
+	)
+	for _, p := range ps {
+		candidate := &traceContext{}
+		ok, err := p.extract(carrier, candidate)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			tc, found = candidate, true
+			break
+		}
+	}
+	for _, p := range ps {
+		if be, ok := p.(baggageExtractor); ok {
+			be.extractBaggage(carrier, tc)
+		}
+	}
+	return tc, found, errs
+}
+
+// b3Propagator implements the B3 formats. Both the single and the multi headers
+// are accepted on extract, while opts selects the headers to inject.
+type b3Propagator struct {
+	opts []b3.InjectOption
+}
+
+var b3Headers = []string{b3.TraceID, b3.SpanID, b3.ParentSpanID, b3.Sampled, b3.Flags, b3.Context}
+
+func (p b3Propagator) inject(tc *traceContext, carrier textMap) {
+	m := make(b3.Map)
+	if err := m.Inject(p.opts...)(tc.spanContext); err != nil {
+		return
+	}
+	for k, v := range m {
+		carrier.Set(k, v)
+	}
+}
+
+func (b3Propagator) owns(key string) bool {
+	for _, h := range b3Headers {
+		if key == strings.ToLower(h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p b3Propagator) extract(carrier textMap, tc *traceContext) (bool, error) {
+	// the single header is parsed here, as b3.ParseSingleHeader of zipkin-go
+	// v0.2.2 drops a digit of the trace id.
+	var singleErr error
+	if single := carrier.Get(b3.Context); single != "" {
+		sc, err := parseB3Single(single)
+		if err == nil {
+			tc.spanContext = sc
+			return true, nil
+		}
+		singleErr = err
+	}
+	m := make(b3.Map)
+	for _, h := range b3Headers {
+		if v := carrier.Get(h); v != "" && h != b3.Context {
+			m[h] = v
+		}
+	}
+	if len(m) == 0 {
+		return false, singleErr
+	}
+	sc, err := m.Extract()
+	if err != nil || sc == nil {
+		return false, err
+	}
+	tc.spanContext = *sc
+	return true, nil
+}
+
+// parseB3Single parses the b3 header: {trace-id}-{span-id}-{sampled}-{parent-span-id},
+// where the sampling state and the parent span id are optional, or {sampled} only.
+func parseB3Single(h string) (model.SpanContext, error) {
+	var sc model.SpanContext
+	parts := strings.Split(h, "-")
+	if len(parts) == 1 {
+		return sc, setB3Sampling(&sc, parts[0])
+	}
+	if len(parts) > 4 || (len(parts[0]) != 16 && len(parts[0]) != 32) {
+		return sc, b3.ErrInvalidTraceIDValue
+	}
+	traceID, err := model.TraceIDFromHex(parts[0])
+	if err != nil || traceID.Empty() {
+		return sc, b3.ErrInvalidTraceIDValue
+	}
+	sc.TraceID = traceID
+	id, ok := parseB3ID(parts[1])
+	if !ok {
+		return sc, b3.ErrInvalidSpanIDValue
+	}
+	sc.ID = id
+	if len(parts) > 2 {
+		if err := setB3Sampling(&sc, parts[2]); err != nil {
+			return sc, err
+		}
+	}
+	if len(parts) > 3 {
+		parentID, ok := parseB3ID(parts[3])
+		if !ok {
+			return sc, b3.ErrInvalidParentSpanIDValue
+		}
+		sc.ParentID = &parentID
+	}
+	return sc, nil
+}
+
+func parseB3ID(h string) (model.ID, bool) {
+	if len(h) != 16 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(h, 16, 64)
+	return model.ID(id), err == nil && id != 0
+}
+
+func setB3Sampling(sc *model.SpanContext, sampling string) error {
+	switch sampling {
+	case "d":
+		sc.Debug = true
+	case "1", "0":
+		sampled := sampling == "1"
+		sc.Sampled = &sampled
+	default:
+		return b3.ErrInvalidSampledByte
+	}
+	return nil
+}
+
+// w3cPropagator implements the W3C Trace Context format,
+// see https://www.w3.org/TR/trace-context/.
+type w3cPropagator struct{}
+
+func (w3cPropagator) inject(tc *traceContext, carrier textMap) {
+	sc := tc.spanContext
+	if sc.TraceID.Empty() || sc.ID == 0 {
+		return
+	}
+	flags := "00"
+	if sc.Debug || (sc.Sampled != nil && *sc.Sampled) {
+		flags = "01"
+	}
+	carrier.Set(traceParentHeader, fmt.Sprintf("00-%016x%016x-%016x-%s", sc.TraceID.High, sc.TraceID.Low, uint64(sc.ID), flags))
+	if tc.traceState != "" {
+		carrier.Set(traceStateHeader, tc.traceState)
+	}
+}
+
+func (w3cPropagator) owns(key string) bool {
+	return key == traceParentHeader || key == traceStateHeader
+}
+
+func (w3cPropagator) extract(carrier textMap, tc *traceContext) (bool, error) {
+	traceParent := strings.TrimSpace(carrier.Get(traceParentHeader))
+	if traceParent == "" {
+		return false, nil
+	}
+	sc, err := parseTraceParent(traceParent)
+	if err != nil {
+		return false, err
+	}
+	tc.spanContext = sc
+	tc.traceState = carrier.Get(traceStateHeader)
+	return true, nil
+}
+
+// parseTraceParent parses the traceparent header: {version}-{trace-id}-{parent-id}-{flags}.
+func parseTraceParent(h string) (model.SpanContext, error) {
+	var sc model.SpanContext
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errInvalidTraceParent
+	}
+	if _, err := strconv.ParseUint(parts[0], 16, 8); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	traceID, err := model.TraceIDFromHex(parts[1])
+	if err != nil || traceID.Empty() {
+		return sc, errInvalidTraceParent
+	}
+	id, err := strconv.ParseUint(parts[2], 16, 64)
+	if err != nil || id == 0 {
+		return sc, errInvalidTraceParent
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, errInvalidTraceParent
+	}
+	sampled := flags&1 == 1
+	sc.TraceID = traceID
+	sc.ID = model.ID(id)
+	sc.Sampled = &sampled
+	return sc, nil
+}
+
+// jaegerPropagator implements the Jaeger format, see
+// https://www.jaegertracing.io/docs/1.21/client-libraries/#propagation-format.
+// Values in http headers are URL encoded as Jaeger clients do.
+type jaegerPropagator struct{}
+
+func (jaegerPropagator) inject(tc *traceContext, carrier textMap) {
+	_, urlEncoding := carrier.(httpHeaderTextMap)
+	sc := tc.spanContext
+	if !sc.TraceID.Empty() && sc.ID != 0 {
+		var parentID uint64
+		if sc.ParentID != nil {
+			parentID = uint64(*sc.ParentID)
+		}
+		var flags byte
+		if sc.Sampled != nil && *sc.Sampled {
+			flags |= 1
+		}
+		if sc.Debug {
+			flags |= 3
+		}
+		carrier.Set(jaegerHeader, fmt.Sprintf("%s:%x:%x:%x", sc.TraceID, uint64(sc.ID), parentID, flags))
+	}
+	for k, v := range tc.baggage {
+		if urlEncoding {
+			v = url.QueryEscape(v)
+		}
+		carrier.Set(jaegerBaggagePrefix+k, v)
+	}
+}
+
+func (jaegerPropagator) owns(key string) bool {
+	return key == jaegerHeader || strings.HasPrefix(key, jaegerBaggagePrefix)
+}
+
+func (jaegerPropagator) extract(carrier textMap, tc *traceContext) (bool, error) {
+	h := jaegerDecode(carrier, carrier.Get(jaegerHeader))
+	if h == "" {
+		return false, nil
+	}
+	sc, err := parseJaegerTraceID(h)
+	if err != nil {
+		return false, err
+	}
+	tc.spanContext = sc
+	return true, nil
+}
+
+func (jaegerPropagator) extractBaggage(carrier textMap, tc *traceContext) {
+	_ = carrier.ForeachKey(func(key, val string) error {
+		if k := strings.ToLower(key); strings.HasPrefix(k, jaegerBaggagePrefix) && len(k) > len(jaegerBaggagePrefix) {
+			tc.setBaggageItem(k[len(jaegerBaggagePrefix):], jaegerDecode(carrier, val))
+		}
+		return nil
+	})
+}
+
+// parseJaegerTraceID parses the uber-trace-id header: {trace-id}:{span-id}:{parent-span-id}:{flags}.
+func parseJaegerTraceID(h string) (model.SpanContext, error) {
+	var sc model.SpanContext
+	parts := strings.Split(h, ":")
+	if len(parts) != 4 || len(parts[0]) == 0 || len(parts[0]) > 32 {
+		return sc, errInvalidJaegerTrace
+	}
+	traceID, err := model.TraceIDFromHex(parts[0])
+	if err != nil || traceID.Empty() {
+		return sc, errInvalidJaegerTrace
+	}
+	id, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil || id == 0 {
+		return sc, errInvalidJaegerTrace
+	}
+	parentID, err := strconv.ParseUint(parts[2], 16, 64)
+	if err != nil {
+		return sc, errInvalidJaegerTrace
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, errInvalidJaegerTrace
+	}
+	sc.TraceID = traceID
+	sc.ID = model.ID(id)
+	if parentID != 0 {
+		pid := model.ID(parentID)
+		sc.ParentID = &pid
+	}
+	sampled := flags&1 == 1
+	sc.Sampled = &sampled
+	sc.Debug = flags&2 == 2
+	return sc, nil
+}
+
+// jaegerDecode decodes the URL encoded values in http headers.
+func jaegerDecode(carrier textMap, v string) string {
+	if _, ok := carrier.(httpHeaderTextMap); !ok {
+		return v
+	}
+	if unescaped, err := url.QueryUnescape(v); err == nil {
+		return unescaped
+	}
+	return v
+}
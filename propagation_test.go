@@ -0,0 +1,313 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+func newTestSpanContext(sampled bool) model.SpanContext {
+	parentID := model.ID(0x1)
+	return model.SpanContext{
+		TraceID:  model.TraceID{High: 0x4bf92f3577b34da6, Low: 0xa3ce929d0e0e4736},
+		ID:       model.ID(0xf067aa0ba902b7),
+		ParentID: &parentID,
+		Sampled:  &sampled,
+	}
+}
+
+func Test_newPropagators(t *testing.T) {
+	ps, err := newPropagators(nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, propagators{b3Propagator{}}, ps)
+
+	ps, err = newPropagators([]string{W3CPropagation, B3Propagation, B3SinglePropagation, JaegerPropagation}, B3InjectBoth)
+	assert.Nil(t, err)
+	assert.Len(t, ps, 4)
+
+	_, err = newPropagators([]string{"xray"}, "")
+	assert.EqualError(t, err, `unknown propagation format "xray"`)
+	_, err = newPropagators(nil, "compact")
+	assert.EqualError(t, err, `unknown b3 inject style "compact"`)
+}
+
+func Test_propagators_inject(t *testing.T) {
+	ps, err := newPropagators([]string{B3Propagation, B3SinglePropagation, W3CPropagation}, "")
+	assert.Nil(t, err)
+	tc := &traceContext{spanContext: newTestSpanContext(true), traceState: "congo=t61rcWkgMzE"}
+
+	md := metadataTextMap{}
+	ps.inject(tc, md)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", md.Get("x-b3-traceid"))
+	assert.Equal(t, "00f067aa0ba902b7", md.Get("x-b3-spanid"))
+	assert.Equal(t, "1", md.Get("x-b3-sampled"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1-0000000000000001", md.Get("b3"))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", md.Get("traceparent"))
+	assert.Equal(t, "congo=t61rcWkgMzE", md.Get("tracestate"))
+
+	header := http.Header{}
+	ps.inject(tc, httpHeaderTextMap(header))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", header.Get("Traceparent"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", header.Get("X-B3-TraceId"))
+
+	// 64 bit trace ids are left padded in traceparent
+	sc := newTestSpanContext(false)
+	sc.TraceID.High = 0
+	md = metadataTextMap{}
+	w3cPropagator{}.inject(&traceContext{spanContext: sc}, md)
+	assert.Equal(t, "00-0000000000000000a3ce929d0e0e4736-00f067aa0ba902b7-00", md.Get("traceparent"))
+	assert.Equal(t, "", md.Get("tracestate"))
+
+	// nothing is injected without ids
+	md = metadataTextMap{}
+	w3cPropagator{}.inject(&traceContext{}, md)
+	b3Propagator{}.inject(&traceContext{}, md)
+	assert.Empty(t, md)
+}
+
+func Test_propagators_extract(t *testing.T) {
+	ps, err := newPropagators([]string{W3CPropagation, B3Propagation}, "")
+	assert.Nil(t, err)
+
+	md := metadataTextMap{}
+	_, found, errs := ps.extract(md)
+	assert.False(t, found)
+	assert.Empty(t, errs)
+
+	md.Set("x-b3-traceid", "0000000000000001")
+	md.Set("x-b3-spanid", "0000000000000002")
+	md.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	md.Set("tracestate", "congo=t61rcWkgMzE")
+	tc, found, errs := ps.extract(md)
+	assert.True(t, found)
+	assert.Empty(t, errs)
+	assert.Equal(t, model.TraceID{High: 0x4bf92f3577b34da6, Low: 0xa3ce929d0e0e4736}, tc.spanContext.TraceID)
+	assert.Equal(t, model.ID(0xf067aa0ba902b7), tc.spanContext.ID)
+	assert.True(t, *tc.spanContext.Sampled)
+	assert.Equal(t, "congo=t61rcWkgMzE", tc.traceState)
+
+	// falls back to b3 if traceparent is malformed
+	md.Set("traceparent", "00-xyz")
+	tc, found, errs = ps.extract(md)
+	assert.True(t, found)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, model.TraceID{Low: 1}, tc.spanContext.TraceID)
+
+	header := http.Header{}
+	header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	tc, found, _ = ps.extract(httpHeaderTextMap(header))
+	assert.True(t, found)
+	assert.False(t, *tc.spanContext.Sampled)
+}
+
+func Test_b3Propagator_injectStyle(t *testing.T) {
+	tc := &traceContext{spanContext: newTestSpanContext(true)}
+	tests := []struct {
+		style      string
+		wantSingle bool
+		wantMulti  bool
+	}{
+		{"", false, true},
+		{B3InjectMulti, false, true},
+		{B3InjectSingle, true, false},
+		{B3InjectBoth, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			ps, err := newPropagators(nil, tt.style)
+			assert.Nil(t, err)
+			md := metadataTextMap{}
+			ps.inject(tc, md)
+			header := http.Header{}
+			ps.inject(tc, httpHeaderTextMap(header))
+			assert.Equal(t, tt.wantSingle, md.Get("b3") != "")
+			assert.Equal(t, tt.wantSingle, header.Get("B3") != "")
+			assert.Equal(t, tt.wantMulti, md.Get("x-b3-traceid") != "")
+			assert.Equal(t, tt.wantMulti, header.Get("X-B3-TraceId") != "")
+		})
+	}
+}
+
+func Test_b3Propagator_extractSingle(t *testing.T) {
+	ps, err := newPropagators(nil, "")
+	assert.Nil(t, err)
+
+	md := metadataTextMap{}
+	md.Set("b3", "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0")
+	tc, found, errs := ps.extract(md)
+	assert.True(t, found)
+	assert.Empty(t, errs)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.spanContext.TraceID.String())
+	assert.Equal(t, model.ID(0xf067aa0ba902b7), tc.spanContext.ID)
+	assert.False(t, *tc.spanContext.Sampled)
+
+	md = metadataTextMap{}
+	md.Set("b3", "64fe8b2a57d3eff7-00f067aa0ba902b7-1-e457b5a2e4d86bd1")
+	tc, found, errs = ps.extract(md)
+	assert.True(t, found)
+	assert.Empty(t, errs)
+	assert.Equal(t, model.TraceID{Low: 0x64fe8b2a57d3eff7}, tc.spanContext.TraceID)
+	assert.Equal(t, model.ID(0xe457b5a2e4d86bd1), *tc.spanContext.ParentID)
+	assert.True(t, *tc.spanContext.Sampled)
+
+	md = metadataTextMap{}
+	md.Set("b3", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1")
+	tc, _, _ = ps.extract(md)
+	assert.Equal(t, model.TraceID{High: 0x80f198ee56343ba8, Low: 0x64fe8b2a57d3eff7}, tc.spanContext.TraceID)
+	assert.Nil(t, tc.spanContext.Sampled)
+
+	header := http.Header{}
+	header.Set("b3", "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-d")
+	tc, found, _ = ps.extract(httpHeaderTextMap(header))
+	assert.True(t, found)
+	assert.True(t, tc.spanContext.Debug)
+
+	// sampling decision only
+	md = metadataTextMap{}
+	md.Set("b3", "0")
+	tc, found, _ = ps.extract(md)
+	assert.True(t, found)
+	assert.True(t, tc.spanContext.TraceID.Empty())
+	assert.False(t, *tc.spanContext.Sampled)
+}
+
+func Test_parseB3Single(t *testing.T) {
+	tests := []struct {
+		header  string
+		wantErr bool
+	}{
+		{"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90", false},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd1-d", false},
+		{"1", false},
+		{"x", true},
+		{"64fe8b2a57d3eff-e457b5a2e4d86bd1-1", true},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd-1", true},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd1-2", true},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b9", true},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90-1", true},
+		{"0000000000000000-e457b5a2e4d86bd1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			_, err := parseB3Single(tt.header)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_parseTraceParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true},
+		{"short trace id", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", true},
+		{"bad flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz", true},
+		{"bad version", "zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTraceParent(tt.header)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_traceContextFromContext(t *testing.T) {
+	assert.Nil(t, traceContextFromContext(context.Background()))
+	tc := &traceContext{traceState: "a=b"}
+	ctx, _ := codec.WithNewMessage(context.Background())
+	assert.Equal(t, tc, traceContextFromContext(contextWithTraceContext(ctx, tc)))
+}
+
+func Test_jaegerPropagator(t *testing.T) {
+	ps, err := newPropagators([]string{JaegerPropagation}, "")
+	assert.Nil(t, err)
+	tc := &traceContext{
+		spanContext: newTestSpanContext(true),
+		baggage:     map[string]string{"tenant": "a b"},
+	}
+
+	md := metadataTextMap{}
+	ps.inject(tc, md)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736:f067aa0ba902b7:1:1", md.Get("uber-trace-id"))
+	assert.Equal(t, "a b", md.Get("uberctx-tenant"))
+
+	got, found, errs := ps.extract(md)
+	assert.True(t, found)
+	assert.Empty(t, errs)
+	assert.Equal(t, tc.spanContext, got.spanContext)
+	assert.Equal(t, tc.baggage, got.baggage)
+
+	// values in http headers are url encoded
+	header := http.Header{}
+	ps.inject(tc, httpHeaderTextMap(header))
+	assert.Equal(t, "a+b", header.Get("Uberctx-Tenant"))
+	header.Set("Uber-Trace-Id", "4bf92f3577b34da6%3Af067aa0ba902b7%3A0%3A3")
+	got, found, _ = ps.extract(httpHeaderTextMap(header))
+	assert.True(t, found)
+	assert.True(t, got.spanContext.Debug)
+	assert.True(t, *got.spanContext.Sampled)
+	assert.Nil(t, got.spanContext.ParentID)
+	assert.Equal(t, model.TraceID{Low: 0x4bf92f3577b34da6}, got.spanContext.TraceID)
+	assert.Equal(t, map[string]string{"tenant": "a b"}, got.baggage)
+}
+
+func Test_propagators_extractJaegerBaggage(t *testing.T) {
+	ps, err := newPropagators([]string{B3Propagation, JaegerPropagation}, "")
+	assert.Nil(t, err)
+	md := metadataTextMap{}
+	md.Set("x-b3-traceid", "0000000000000001")
+	md.Set("x-b3-spanid", "0000000000000002")
+	md.Set("uberctx-experiment", "42")
+	tc, found, _ := ps.extract(md)
+	assert.True(t, found)
+	assert.Equal(t, model.TraceID{Low: 1}, tc.spanContext.TraceID)
+	assert.Equal(t, map[string]string{"experiment": "42"}, tc.baggage)
+}
+
+func Test_parseJaegerTraceID(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"valid", "4bf92f3577b34da6a3ce929d0e0e4736:f067aa0ba902b7:0:1", false},
+		{"short trace id", "1:2:0:0", false},
+		{"missing fields", "1:2:0", true},
+		{"zero trace id", "0:2:0:1", true},
+		{"zero span id", "1:0:0:1", true},
+		{"long trace id", "4bf92f3577b34da6a3ce929d0e0e47360:2:0:1", true},
+		{"bad parent", "1:2:x:1", true},
+		{"bad flags", "1:2:0:x", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJaegerTraceID(tt.header)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
@@ -0,0 +1,413 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"gopkg.in/yaml.v3"
+	"trpc.group/trpc-go/trpc-go/config"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// reloadableTracer holds the sampler and the reporter of a tracer built with
+// watch enabled, which are swapped when the config is reloaded.
+type reloadableTracer struct {
+	sampler  swappableSampler
+	reporter swappableReporter
+}
+
+// newReloadableTracer news a opentracing tracer which samples with the sampler
+// of c and reports through rep, until they are swapped.
+func (c *Config) newReloadableTracer(rep *sharedReporter) (opentracing.Tracer, *reloadableTracer, error) {
+	s, err := c.newSamplers(rep)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &reloadableTracer{}
+	t.swap(s, rep)
+	zipkinTracer, err := c.newZipkinTracerWithSampler(&t.reporter, t.sampler.sample)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the wrapper decides all new traces, so that swapped samplers deciding
+	// by operation take effect
+	tracer := &operationSamplingTracer{
+		Tracer:  zipkinOpentracing.Wrap(zipkinTracer),
+		sampler: &t.sampler,
+		service: c.ServiceName,
+	}
+	return tracer, t, nil
+}
+
+// swap swaps in s and rep.
+func (t *reloadableTracer) swap(s samplers, rep reporter.Reporter) {
+	t.sampler.v.Store(s)
+	t.reporter.swap(rep)
+}
+
+// samplers holds a sampler and the sampler which should decide new traces by
+// operation, if any.
+type samplers struct {
+	sampler   zipkin.Sampler
+	opSampler operationSampler
+}
+
+// newSamplers news the samplers of c, which are closed along with rep.
+func (c *Config) newSamplers(rep *sharedReporter) (samplers, error) {
+	sampler, opSampler, err := c.newZipkinSampler()
+	if err != nil {
+		return samplers{}, err
+	}
+	closeWithReporter(opSampler, rep)
+	return samplers{sampler: sampler, opSampler: opSampler}, nil
+}
+
+// swappableSampler samples with the samplers stored last.
+type swappableSampler struct {
+	v atomic.Value // samplers
+}
+
+// sample implements zipkin.Sampler.
+func (s *swappableSampler) sample(id uint64) bool {
+	return s.v.Load().(samplers).sampler(id)
+}
+
+func (s *swappableSampler) sampleOperation(op samplingOperation, id uint64) bool {
+	cur := s.v.Load().(samplers)
+	if cur.opSampler != nil {
+		return cur.opSampler.sampleOperation(op, id)
+	}
+	return cur.sampler(id)
+}
+
+// swappableReporter reports through the reporter swapped in last. Once swap
+// returns, no span is sent to the previous reporter any more, so it can be
+// flushed and closed without dropping spans.
+type swappableReporter struct {
+	mu  sync.RWMutex
+	rep reporter.Reporter
+}
+
+// Send implements reporter.Reporter.
+func (r *swappableReporter) Send(s model.SpanModel) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.rep.Send(s)
+}
+
+// Close implements reporter.Reporter.
+func (r *swappableReporter) Close() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rep.Close()
+}
+
+func (r *swappableReporter) swap(rep reporter.Reporter) {
+	r.mu.Lock()
+	r.rep = rep
+	r.mu.Unlock()
+}
+
+// watch reloads the plugin config whenever the trpc config file at path is
+// written, through the file config provider of trpc.
+func (z *zipkinPlugin) watch(path string) error {
+	provider := config.GetProvider("file")
+	if provider == nil {
+		return errors.New("file config provider not found")
+	}
+	// the file provider only watches the files it has read
+	if _, err := provider.Read(path); err != nil {
+		return err
+	}
+	provider.Watch(func(changed string, data []byte) {
+		if filepath.Clean(changed) != filepath.Clean(path) {
+			return
+		}
+		cfg, err := pluginConfig(data, z.name)
+		if err == nil {
+			err = z.reload(cfg)
+		}
+		if err != nil {
+			log.Errorf("trpc-opentracing-zipkin: config update rejected: %v", err)
+		}
+	})
+	return nil
+}
+
+// envPattern matches the ${var} environment variables, which trpc expands in
+// its config file.
+var envPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// pluginConfig decodes the config of the plugin named name from the content
+// of the trpc config file. The rest of the file is left alone, as loading it
+// with trpc.LoadConfig would change framework-wide settings.
+func pluginConfig(data []byte, name string) (*Config, error) {
+	data = envPattern.ReplaceAllFunc(data, func(v []byte) []byte {
+		return []byte(os.Getenv(string(envPattern.FindSubmatch(v)[1])))
+	})
+	var file struct {
+		Plugins map[string]map[string]yaml.Node `yaml:"plugins"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	node, ok := file.Plugins[pluginType][name]
+	if !ok {
+		return nil, fmt.Errorf("plugin %s-%s not found", pluginType, name)
+	}
+	cfg := &Config{}
+	if err := node.Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// reload rebuilds the samplers and reporters of the tracers from cfg and swaps
+// them in, then flushes and closes the previous reporters. Nothing is changed
+// if cfg is invalid or only changes fields which take effect after restart.
+func (z *zipkinPlugin) reload(cfg *Config) error {
+	cfg.withDefault()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	changes := configDiff(z.cfg, cfg)
+	if len(changes) == 0 {
+		return nil
+	}
+	var restart []string
+	for _, c := range changes {
+		if !c.reloadable(z.cfg, cfg) {
+			restart = append(restart, c.path)
+		}
+	}
+	if len(restart) > 0 {
+		log.Warnf("trpc-opentracing-zipkin: changes of %s take effect after restart", strings.Join(restart, ", "))
+	}
+	if len(restart) == len(changes) {
+		return nil
+	}
+
+	var reps []*sharedReporter
+	newReporter := func(c *Config) (*sharedReporter, error) {
+		rep, err := c.newReporter()
+		if err != nil {
+			return nil, fmt.Errorf("create reporter failed: %w", err)
+		}
+		sr := newSharedReporter(rep)
+		reps = append(reps, sr)
+		return sr, nil
+	}
+	type update struct {
+		tracer   *reloadableTracer
+		samplers samplers
+		reporter *sharedReporter
+	}
+	var updates []update
+	err := func() error {
+		globalRep, err := newReporter(cfg)
+		if err != nil {
+			return err
+		}
+		for service, t := range z.reloadables {
+			u := update{tracer: t, reporter: globalRep}
+			c := *cfg
+			if service != "" {
+				var enabled bool
+				if c, enabled = cfg.serviceConfig(service); !enabled {
+					// tracing stops, but the tracer is kept until restart
+					u.samplers = samplers{sampler: zipkin.NeverSample}
+					updates = append(updates, u)
+					continue
+				}
+				c.withServiceName(service)
+				if c.Reporter != cfg.Reporter {
+					if u.reporter, err = newReporter(&c); err != nil {
+						return err
+					}
+				}
+			}
+			if u.samplers, err = c.newSamplers(u.reporter); err != nil {
+				return fmt.Errorf("create sampler failed: %w", err)
+			}
+			updates = append(updates, u)
+		}
+		return nil
+	}()
+	if err != nil {
+		for _, r := range reps {
+			_ = r.Close()
+		}
+		return err
+	}
+
+	for _, u := range updates {
+		u.tracer.swap(u.samplers, u.reporter)
+	}
+	old := z.reporters
+	z.reporters, z.cfg, z.shutdownTimeout = reps, cfg, cfg.shutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), z.shutdownTimeout)
+	defer cancel()
+	if err := shutdownReporters(ctx, old...); err != nil {
+		log.Warnf("trpc-opentracing-zipkin: close previous reporters failed: %v", err)
+	}
+
+	log.Infof("trpc-opentracing-zipkin: config reloaded: %s", joinChanges(changes))
+	return nil
+}
+
+// fieldChange is a change of a config field, with its yaml path.
+type fieldChange struct {
+	path string
+	from string
+	to   string
+}
+
+func (c fieldChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.path, c.from, c.to)
+}
+
+// reloadable tells whether the change is applied by reload.
+func (c fieldChange) reloadable(configs ...*Config) bool {
+	path := c.path
+	for _, cfg := range configs {
+		for name := range cfg.Services {
+			if p := "services." + name + "."; strings.HasPrefix(path, p) {
+				path = strings.TrimPrefix(path, p)
+				break
+			}
+		}
+	}
+	for _, prefix := range []string{"sampler", "reporter"} {
+		if path == prefix || strings.HasPrefix(path, prefix+".") {
+			return true
+		}
+	}
+	return path == "shutdown_timeout_seconds"
+}
+
+func joinChanges(changes []fieldChange) string {
+	ss := make([]string, 0, len(changes))
+	for _, c := range changes {
+		ss = append(ss, c.String())
+	}
+	return strings.Join(ss, ", ")
+}
+
+// configDiff lists the fields changed from old to updated in path order. Values
+// of secrets and passwords are masked. Unset fields and fields set to their zero
+// value are the same, so that the fields of a newly set struct which are left
+// empty are not listed.
+func configDiff(old, updated *Config) []fieldChange {
+	from, to := flattenConfig(old), flattenConfig(updated)
+	paths := make([]string, 0, len(from)+len(to))
+	for p := range from {
+		paths = append(paths, p)
+	}
+	for p := range to {
+		if _, ok := from[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes []fieldChange
+	for _, p := range paths {
+		fv, ok1 := from[p]
+		tv, ok2 := to[p]
+		if (!ok1 && zeroValue(tv)) || (!ok2 && zeroValue(fv)) {
+			continue
+		}
+		f, t := fmt.Sprint(fv), fmt.Sprint(tv)
+		if ok1 && ok2 && f == t {
+			continue
+		}
+		if !ok1 {
+			f = "<unset>"
+		}
+		if !ok2 {
+			t = "<unset>"
+		}
+		if name := strings.ToLower(p); strings.Contains(name, "secret") || strings.Contains(name, "password") {
+			f, t = "***", "***"
+		}
+		changes = append(changes, fieldChange{path: p, from: f, to: t})
+	}
+	return changes
+}
+
+// zeroValue tells whether the yaml scalar v is the zero value of its type.
+func zeroValue(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return v == ""
+	case int:
+		return v == 0
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	}
+	return false
+}
+
+// flattenConfig maps the yaml path of each set field of c to its value.
+func flattenConfig(c *Config) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if c == nil {
+		return fields
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fields
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return fields
+	}
+	flatten("", v, fields)
+	return fields
+}
+
+func flatten(path string, v interface{}, fields map[string]interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, e := range v {
+			flatten(joinField(path, k), e, fields)
+		}
+	case []interface{}:
+		for i, e := range v {
+			flatten(fmt.Sprintf("%s[%d]", path, i), e, fields)
+		}
+	default:
+		fields[path] = v
+	}
+}
@@ -0,0 +1,250 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	trpc "trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/config"
+)
+
+const watchConf = `
+plugins:
+ tracing:
+   zipkin:
+     watch: true
+     reporter:
+       type: noop
+     sampler:
+       type: never
+     services:
+       trpc.app.server.Admin:
+         sampler:
+           type: never
+`
+
+func sampled(tracer opentracing.Tracer) bool {
+	span := tracer.StartSpan("/a")
+	defer span.Finish()
+	s := span.Context().(zipkinOpentracing.SpanContext).Sampled
+	return s != nil && *s
+}
+
+// setupWatchPlugin sets up the plugin with conf, and returns it with a func
+// which closes it and restores the global config.
+func setupWatchPlugin(t *testing.T, conf string) (*zipkinPlugin, func()) {
+	old := trpc.GlobalConfig()
+	global := &trpc.Config{}
+	global.Server.Service = []*trpc.ServiceConfig{
+		{Name: "trpc.app.server.Public", IP: "127.0.0.1", Port: 8001},
+		{Name: "trpc.app.server.Admin", IP: "127.0.0.1", Port: 8002},
+	}
+	trpc.SetGlobalConfig(global)
+
+	cfg := trpc.Config{}
+	assert.Nil(t, yaml.Unmarshal([]byte(conf), &cfg))
+	zipkinCfg := cfg.Plugins["tracing"]["zipkin"]
+	z := &zipkinPlugin{}
+	assert.Nil(t, z.Setup("zipkin", &zipkinCfg))
+	return z, func() {
+		_ = z.Close()
+		trpc.SetGlobalConfig(old)
+	}
+}
+
+func TestZipkinPlugin_Reload(t *testing.T) {
+	z, cleanup := setupWatchPlugin(t, watchConf)
+	defer cleanup()
+	assert.Len(t, z.reloadables, 3)
+	assert.False(t, sampled(z.tracers["trpc.app.server.Public"]))
+	oldReporters := z.reporters
+
+	// the admin service keeps its own sampler, and gets its own reporter
+	assert.Nil(t, z.reload(&Config{
+		Watch:    true,
+		Reporter: &ReporterConfig{Type: NoopReporter},
+		Sampler:  &SamplerConfig{Type: AlwaysSampler},
+		Services: map[string]*ServiceConfig{
+			"trpc.app.server.Admin": {
+				Sampler:  &SamplerConfig{Type: NeverSampler},
+				Reporter: &ReporterConfig{Type: NoopReporter},
+			},
+		},
+	}))
+	assert.True(t, sampled(z.tracers["trpc.app.server.Public"]))
+	assert.False(t, sampled(z.tracers["trpc.app.server.Admin"]))
+	assert.True(t, sampled(opentracing.GlobalTracer()))
+	assert.Len(t, z.reporters, 2)
+	for _, r := range oldReporters {
+		assert.True(t, r.closed)
+	}
+
+	// invalid updates change nothing
+	reporters := z.reporters
+	assert.NotNil(t, z.reload(&Config{
+		Watch:    true,
+		Reporter: &ReporterConfig{Type: NoopReporter},
+		Sampler:  &SamplerConfig{Type: "sometimes"},
+	}))
+	assert.True(t, sampled(z.tracers["trpc.app.server.Public"]))
+	assert.Equal(t, reporters, z.reporters)
+	assert.False(t, z.reporters[0].closed)
+
+	// changes taking effect after restart only keep the reporters
+	cfg := *z.cfg
+	cfg.TraceID128 = !cfg.TraceID128
+	assert.Nil(t, z.reload(&cfg))
+	assert.Equal(t, reporters, z.reporters)
+	assert.False(t, z.reporters[0].closed)
+	assert.NotEqual(t, cfg.TraceID128, z.cfg.TraceID128)
+}
+
+// watchProvider reads files through the file provider, and keeps the watch
+// callbacks so that tests can trigger them.
+type watchProvider struct {
+	config.DataProvider
+	callbacks []config.ProviderCallback
+}
+
+func (p *watchProvider) Watch(cb config.ProviderCallback) {
+	p.callbacks = append(p.callbacks, cb)
+}
+
+func TestZipkinPlugin_ReloadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trpc_go.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(watchConf), 0644))
+
+	file := config.GetProvider("file")
+	provider := &watchProvider{DataProvider: file}
+	config.RegisterProvider(provider)
+	defer config.RegisterProvider(file)
+
+	z, cleanup := setupWatchPlugin(t, watchConf)
+	defer cleanup()
+	provider.callbacks = nil
+	assert.Nil(t, z.watch(path))
+	assert.NotNil(t, z.watch(filepath.Join(dir, "not-exist.yaml")))
+	assert.Len(t, provider.callbacks, 1)
+
+	updated := []byte(`
+plugins:
+ tracing:
+   zipkin:
+     watch: true
+     reporter:
+       type: noop
+     sampler:
+       type: always
+`)
+	// writes of other files are ignored
+	provider.callbacks[0](filepath.Join(dir, "other.yaml"), updated)
+	assert.False(t, sampled(z.tracers["trpc.app.server.Admin"]))
+	provider.callbacks[0](path, updated)
+	assert.True(t, sampled(z.tracers["trpc.app.server.Admin"]))
+}
+
+func Test_pluginConfig(t *testing.T) {
+	os.Setenv("ZIPKIN_TEST_SAMPLER", "always")
+	defer os.Unsetenv("ZIPKIN_TEST_SAMPLER")
+	data := []byte(`
+global:
+  read_buffer_size: 1
+plugins:
+ tracing:
+   zipkin:
+     sampler:
+       type: ${ZIPKIN_TEST_SAMPLER}
+`)
+	cfg, err := pluginConfig(data, "zipkin")
+	assert.Nil(t, err)
+	assert.Equal(t, AlwaysSampler, cfg.Sampler.Type)
+
+	_, err = pluginConfig(data, "other")
+	assert.EqualError(t, err, "plugin tracing-other not found")
+	_, err = pluginConfig([]byte("plugins: ["), "zipkin")
+	assert.NotNil(t, err)
+}
+
+func Test_reloadableTracer_swap(t *testing.T) {
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	rec1, rec2 := recorder.NewReporter(), recorder.NewReporter()
+	rep1, rep2 := newSharedReporter(rec1), newSharedReporter(rec2)
+	tracer, rt, err := c.newReloadableTracer(rep1)
+	assert.Nil(t, err)
+
+	// spans in flight are reported through the reporter in effect when they finish
+	span := tracer.StartSpan("/a")
+	s, err := c.newSamplers(rep2)
+	assert.Nil(t, err)
+	rt.swap(s, rep2)
+	assert.Nil(t, rep1.Close())
+	span.Finish()
+	assert.Len(t, rec2.Flush(), 1)
+}
+
+func Test_configDiff(t *testing.T) {
+	rate := 0.1
+	old := &Config{
+		Sampler:     &SamplerConfig{Type: AlwaysSampler},
+		Reporter:    &ReporterConfig{Type: NoopReporter},
+		ForceSample: &ForceSampleConfig{Key: "x-force-trace", Secret: "s3cret"},
+		Services: map[string]*ServiceConfig{
+			"trpc.app.server.Admin": {Sampler: &SamplerConfig{Type: NeverSampler}},
+		},
+	}
+	updated := &Config{
+		Sampler:     &SamplerConfig{Type: RemoteSampler, Remote: &RemoteSamplerConfig{File: "s.json", InitialRate: &rate}},
+		Reporter:    &ReporterConfig{Type: NoopReporter},
+		ForceSample: &ForceSampleConfig{Key: "x-force-trace", Secret: "0ther"},
+		Services: map[string]*ServiceConfig{
+			"trpc.app.server.Admin": {Sampler: &SamplerConfig{Type: AlwaysSampler}},
+		},
+		Tags: map[string]string{"env": "test"},
+	}
+	var got []string
+	var restart []string
+	for _, c := range configDiff(old, updated) {
+		got = append(got, c.String())
+		if !c.reloadable(old, updated) {
+			restart = append(restart, c.path)
+		}
+	}
+	assert.Equal(t, []string{
+		"force_sample.secret: *** -> ***",
+		"sampler.remote.file: <unset> -> s.json",
+		"sampler.remote.initial_rate: <unset> -> 0.1",
+		"sampler.type: always -> remote",
+		"services.trpc.app.server.Admin.sampler.type: never -> always",
+		"tags.env: <unset> -> test",
+	}, got)
+	assert.Equal(t, []string{"force_sample.secret", "tags.env"}, restart)
+	assert.Empty(t, configDiff(old, old))
+
+	// zero values are listed once set
+	changes := configDiff(&Config{ErrorSampling: true}, &Config{})
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "error_sampling: true -> false", changes[0].String())
+}
@@ -0,0 +1,443 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+const (
+	defaultRemotePollInterval = time.Minute
+	defaultRemoteTimeout      = 5 * time.Second
+	defaultRemoteInitialRate  = 0.001
+	// maxStrategySize bounds the size of a strategy document.
+	maxStrategySize = 1 << 20
+)
+
+const (
+	probabilisticStrategy = "probabilistic"
+	rateLimitingStrategy  = "ratelimiting"
+)
+
+// remoteSampler samples with the Jaeger sampling strategy of its service, which
+// is polled from a file or an http endpoint. The last good strategy is kept if
+// a poll fails.
+type remoteSampler struct {
+	service     string
+	initialRate float64
+	poller      *strategyPoller
+
+	strategy atomic.Value // *samplingStrategy
+	// doc is the document of the current strategy, guarded by poller.mu
+	doc *strategyDocument
+
+	closeOnce sync.Once
+}
+
+// newRemoteSampler news a sampler at the initial rate, which is updated once
+// the strategy is loaded in the background.
+func newRemoteSampler(c *RemoteSamplerConfig, service string) *remoteSampler {
+	s := &remoteSampler{service: service, initialRate: defaultRemoteInitialRate}
+	if c.InitialRate != nil {
+		s.initialRate = *c.InitialRate
+	}
+	s.strategy.Store(&samplingStrategy{defaultSampler: probabilisticSampler(s.initialRate)})
+	s.poller = subscribePoller(c, s)
+	return s
+}
+
+// sample implements zipkin.Sampler.
+func (s *remoteSampler) sample(id uint64) bool {
+	return s.sampleOperation(samplingOperation{}, id)
+}
+
+func (s *remoteSampler) sampleOperation(op samplingOperation, id uint64) bool {
+	return s.strategy.Load().(*samplingStrategy).sampleOperation(op, id)
+}
+
+// Close stops polling, once no other sampler shares the poller.
+func (s *remoteSampler) Close() error {
+	s.closeOnce.Do(func() { s.poller.unsubscribe(s) })
+	return nil
+}
+
+// poll polls the strategy now.
+func (s *remoteSampler) poll() error {
+	return s.poller.poll()
+}
+
+// apply swaps in the strategy of doc if it has changed. The poller mutex must
+// be held.
+func (s *remoteSampler) apply(doc *strategyDocument, data []byte) error {
+	if reflect.DeepEqual(doc, s.doc) {
+		return nil
+	}
+	strategy, err := doc.strategy(s.service)
+	if err != nil {
+		return err
+	}
+	s.strategy.Store(strategy)
+	s.doc = doc
+	log.Infof("trpc-opentracing-zipkin: sampling strategy of %s updated: %s", s.service, data)
+	return nil
+}
+
+// pollers holds the running pollers by their key, so that the samplers of all
+// tracers polling the same document share one poller.
+var pollers = struct {
+	sync.Mutex
+	m map[string]*strategyPoller
+}{m: make(map[string]*strategyPoller)}
+
+// strategyPoller polls a strategy document for the samplers subscribed to it.
+type strategyPoller struct {
+	key      string
+	url      string
+	file     string
+	interval time.Duration
+	client   *http.Client
+	stop     chan struct{}
+
+	// mu serializes polls, and guards the fields below.
+	mu       sync.Mutex
+	samplers []*remoteSampler
+	doc      *strategyDocument
+	data     []byte
+}
+
+// subscribePoller subscribes s to the poller of c, which is started if there
+// is none.
+func subscribePoller(c *RemoteSamplerConfig, s *remoteSampler) *strategyPoller {
+	p := &strategyPoller{
+		url:      c.URL,
+		file:     c.File,
+		interval: defaultRemotePollInterval,
+		client:   &http.Client{Timeout: defaultRemoteTimeout},
+		stop:     make(chan struct{}),
+	}
+	if c.PollIntervalSeconds > 0 {
+		p.interval = time.Duration(c.PollIntervalSeconds) * time.Second
+	}
+	if c.TimeoutSeconds > 0 {
+		p.client.Timeout = time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	if p.file == "" {
+		// Jaeger endpoints serve the strategy of the service parameter
+		p.url = serviceURL(p.url, s.service)
+	}
+	p.key = fmt.Sprintf("%s|%s|%v|%v", p.file, p.url, p.interval, p.client.Timeout)
+
+	pollers.Lock()
+	defer pollers.Unlock()
+	if running, ok := pollers.m[p.key]; ok {
+		p = running
+	} else {
+		pollers.m[p.key] = p
+		go p.run()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samplers = append(p.samplers, s)
+	if p.doc != nil {
+		if err := s.apply(p.doc, p.data); err != nil {
+			log.Warnf("trpc-opentracing-zipkin: load sampling strategy of %s failed, sampling at %v: %v",
+				s.service, s.initialRate, err)
+		}
+	}
+	return p
+}
+
+// unsubscribe removes s, and stops the poller if it was the last sampler.
+func (p *strategyPoller) unsubscribe(s *remoteSampler) {
+	pollers.Lock()
+	defer pollers.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, sub := range p.samplers {
+		if sub == s {
+			p.samplers = append(p.samplers[:i], p.samplers[i+1:]...)
+			break
+		}
+	}
+	if len(p.samplers) == 0 {
+		delete(pollers.m, p.key)
+		close(p.stop)
+	}
+}
+
+// run loads the strategy, which is left out of the sampler construction so
+// that an unreachable endpoint does not delay startup, then polls it.
+func (p *strategyPoller) run() {
+	if err := p.poll(); err != nil {
+		log.Warnf("trpc-opentracing-zipkin: load sampling strategy from %s failed, sampling at initial rates: %v",
+			p.source(), err)
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.poll(); err != nil {
+				log.Warnf("trpc-opentracing-zipkin: poll sampling strategy from %s failed, keep the last one: %v",
+					p.source(), err)
+			}
+		}
+	}
+}
+
+// poll loads the strategy document and applies it to the samplers, returning
+// the first error.
+func (p *strategyPoller) poll() error {
+	data, err := p.fetch()
+	if err != nil {
+		return err
+	}
+	doc := &strategyDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("parse strategy: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doc, p.data = doc, data
+	var firstErr error
+	for _, s := range p.samplers {
+		if err := s.apply(doc, data); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("strategy of %s: %w", s.service, err)
+		}
+	}
+	return firstErr
+}
+
+func (p *strategyPoller) source() string {
+	if p.file != "" {
+		return p.file
+	}
+	return p.url
+}
+
+func (p *strategyPoller) fetch() ([]byte, error) {
+	if p.file != "" {
+		return ioutil.ReadFile(p.file)
+	}
+	rsp, err := p.client.Get(p.url)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", rsp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(rsp.Body, maxStrategySize))
+}
+
+// serviceURL adds the service parameter to rawURL, unless it is set.
+func serviceURL(rawURL, service string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// validated, fetch reports the error otherwise
+		return rawURL
+	}
+	q := u.Query()
+	if q.Get("service") == "" {
+		q.Set("service", service)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// strategyDocument accepts both the strategies file of the Jaeger collector,
+// which holds the strategies of many services, and the response of the Jaeger
+// sampling endpoint, which holds the strategy of one service.
+type strategyDocument struct {
+	ServiceStrategies []*serviceStrategy `json:"service_strategies"`
+	DefaultStrategy   *serviceStrategy   `json:"default_strategy"`
+
+	ProbabilisticSampling *struct {
+		SamplingRate float64 `json:"samplingRate"`
+	} `json:"probabilisticSampling"`
+	RateLimitingSampling *struct {
+		MaxTracesPerSecond float64 `json:"maxTracesPerSecond"`
+	} `json:"rateLimitingSampling"`
+	OperationSampling *struct {
+		DefaultSamplingProbability       float64 `json:"defaultSamplingProbability"`
+		DefaultLowerBoundTracesPerSecond float64 `json:"defaultLowerBoundTracesPerSecond"`
+		PerOperationStrategies           []struct {
+			Operation             string `json:"operation"`
+			ProbabilisticSampling struct {
+				SamplingRate float64 `json:"samplingRate"`
+			} `json:"probabilisticSampling"`
+		} `json:"perOperationStrategies"`
+	} `json:"operationSampling"`
+}
+
+// serviceStrategy is a strategy in the strategies file of the Jaeger collector.
+type serviceStrategy struct {
+	Service             string             `json:"service"`
+	Type                string             `json:"type"`
+	Param               float64            `json:"param"`
+	OperationStrategies []*serviceStrategy `json:"operation_strategies"`
+	// Operation is set for operation strategies only.
+	Operation string `json:"operation"`
+}
+
+func (d *strategyDocument) strategy(service string) (*samplingStrategy, error) {
+	if d.ServiceStrategies != nil || d.DefaultStrategy != nil {
+		for _, ss := range d.ServiceStrategies {
+			if ss.Service == service {
+				return ss.strategy()
+			}
+		}
+		if d.DefaultStrategy != nil {
+			return d.DefaultStrategy.strategy()
+		}
+		return nil, fmt.Errorf("no strategy for service %s", service)
+	}
+
+	switch {
+	case d.OperationSampling != nil:
+		os := d.OperationSampling
+		if err := checkProbability(os.DefaultSamplingProbability); err != nil {
+			return nil, err
+		}
+		s := &samplingStrategy{
+			defaultSampler: probabilisticSampler(os.DefaultSamplingProbability),
+			operations:     make(map[string]zipkin.Sampler, len(os.PerOperationStrategies)),
+		}
+		if os.DefaultLowerBoundTracesPerSecond > 0 {
+			s.lowerBound = &rateLimitingSampler{minPerOperation: os.DefaultLowerBoundTracesPerSecond}
+		}
+		for _, o := range os.PerOperationStrategies {
+			rate := o.ProbabilisticSampling.SamplingRate
+			if err := checkProbability(rate); err != nil {
+				return nil, fmt.Errorf("operation %s: %w", o.Operation, err)
+			}
+			s.operations[o.Operation] = probabilisticSampler(rate)
+		}
+		return s, nil
+	case d.RateLimitingSampling != nil:
+		return rateLimitingStrategyOf(d.RateLimitingSampling.MaxTracesPerSecond)
+	case d.ProbabilisticSampling != nil:
+		rate := d.ProbabilisticSampling.SamplingRate
+		if err := checkProbability(rate); err != nil {
+			return nil, err
+		}
+		return &samplingStrategy{defaultSampler: probabilisticSampler(rate)}, nil
+	default:
+		return nil, errors.New("empty strategy")
+	}
+}
+
+func (ss *serviceStrategy) strategy() (*samplingStrategy, error) {
+	s := &samplingStrategy{}
+	var err error
+	if s.defaultSampler, err = ss.sampler(); err != nil {
+		return nil, err
+	}
+	if len(ss.OperationStrategies) > 0 {
+		s.operations = make(map[string]zipkin.Sampler, len(ss.OperationStrategies))
+	}
+	for _, o := range ss.OperationStrategies {
+		if s.operations[o.Operation], err = o.sampler(); err != nil {
+			return nil, fmt.Errorf("operation %s: %w", o.Operation, err)
+		}
+	}
+	return s, nil
+}
+
+func (ss *serviceStrategy) sampler() (zipkin.Sampler, error) {
+	switch ss.Type {
+	case probabilisticStrategy:
+		if err := checkProbability(ss.Param); err != nil {
+			return nil, err
+		}
+		return probabilisticSampler(ss.Param), nil
+	case rateLimitingStrategy:
+		s, err := rateLimitingStrategyOf(ss.Param)
+		if err != nil {
+			return nil, err
+		}
+		return s.defaultSampler, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy type %q", ss.Type)
+	}
+}
+
+func rateLimitingStrategyOf(tracesPerSecond float64) (*samplingStrategy, error) {
+	if tracesPerSecond < 0 {
+		return nil, fmt.Errorf("traces per second %v is negative", tracesPerSecond)
+	}
+	if tracesPerSecond == 0 {
+		return &samplingStrategy{defaultSampler: zipkin.NeverSample}, nil
+	}
+	l := newRateLimiter(tracesPerSecond)
+	return &samplingStrategy{defaultSampler: func(uint64) bool { return l.allow() }}, nil
+}
+
+func checkProbability(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("sampling rate %v is not between 0 and 1", rate)
+	}
+	return nil
+}
+
+// samplingStrategy samples operations with their own sampler if any, or the
+// default one. Operations are sampled at least lowerBound times per second.
+type samplingStrategy struct {
+	defaultSampler zipkin.Sampler
+	operations     map[string]zipkin.Sampler
+	lowerBound     *rateLimitingSampler
+}
+
+func (s *samplingStrategy) sampleOperation(op samplingOperation, id uint64) bool {
+	sampler, ok := s.operations[op.name]
+	if !ok {
+		sampler = s.defaultSampler
+	}
+	if sampler(id) {
+		return true
+	}
+	if s.lowerBound == nil {
+		return false
+	}
+	l := s.lowerBound.operationLimiter(op.name)
+	return l != nil && l.allow()
+}
+
+// probabilisticSampler samples the trace ids in the lowest rate of the id space.
+func probabilisticSampler(rate float64) zipkin.Sampler {
+	switch {
+	case rate <= 0:
+		return zipkin.NeverSample
+	case rate >= 1:
+		return zipkin.AlwaysSample
+	}
+	boundary := uint64(rate * math.MaxUint64)
+	return func(id uint64) bool { return id < boundary }
+}
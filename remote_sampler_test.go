@@ -0,0 +1,290 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_strategyDocument(t *testing.T) {
+	const (
+		low  uint64 = 1
+		high uint64 = math.MaxUint64 - 1
+	)
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+		// want is the decision of each operation for low and high ids
+		want map[string][2]bool
+	}{
+		{
+			name: "probabilistic",
+			doc:  `{"strategyType":"PROBABILISTIC","probabilisticSampling":{"samplingRate":0.5}}`,
+			want: map[string][2]bool{"/a": {true, false}},
+		},
+		{
+			name: "ratelimiting",
+			doc:  `{"strategyType":"RATE_LIMITING","rateLimitingSampling":{"maxTracesPerSecond":1}}`,
+			want: map[string][2]bool{"/a": {true, false}},
+		},
+		{
+			name: "per operation",
+			doc: `{"operationSampling":{"defaultSamplingProbability":0,` +
+				`"perOperationStrategies":[{"operation":"/a","probabilisticSampling":{"samplingRate":1}}]}}`,
+			want: map[string][2]bool{"/a": {true, true}, "/b": {false, false}},
+		},
+		{
+			name: "lower bound",
+			doc:  `{"operationSampling":{"defaultSamplingProbability":0,"defaultLowerBoundTracesPerSecond":1}}`,
+			want: map[string][2]bool{"/a": {true, false}, "/b": {true, false}},
+		},
+		{
+			name: "service",
+			doc: `{"service_strategies":[{"service":"trpc.app.server.Service","type":"probabilistic","param":1,` +
+				`"operation_strategies":[{"operation":"/a","type":"probabilistic","param":0}]}],` +
+				`"default_strategy":{"type":"probabilistic","param":0}}`,
+			want: map[string][2]bool{"/a": {false, false}, "/b": {true, true}},
+		},
+		{
+			name: "default",
+			doc:  `{"service_strategies":[],"default_strategy":{"type":"ratelimiting","param":0}}`,
+			want: map[string][2]bool{"/a": {false, false}},
+		},
+		{name: "no service", doc: `{"service_strategies":[{"service":"other","type":"probabilistic","param":1}]}`, wantErr: true},
+		{name: "unknown type", doc: `{"default_strategy":{"type":"adaptive","param":1}}`, wantErr: true},
+		{name: "bad rate", doc: `{"probabilisticSampling":{"samplingRate":2}}`, wantErr: true},
+		{name: "negative limit", doc: `{"rateLimitingSampling":{"maxTracesPerSecond":-1}}`, wantErr: true},
+		{name: "empty", doc: `{}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &strategyDocument{}
+			assert.Nil(t, json.Unmarshal([]byte(tt.doc), doc))
+			s, err := doc.strategy("trpc.app.server.Service")
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			for name, want := range tt.want {
+				op := samplingOperation{name: name}
+				assert.Equal(t, want[0], s.sampleOperation(op, low), name)
+				assert.Equal(t, want[1], s.sampleOperation(op, high), name)
+			}
+		})
+	}
+}
+
+func Test_remoteSampler_http(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		status  = http.StatusOK
+		doc     = `{"probabilisticSampling":{"samplingRate":1}}`
+		service string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		service = r.URL.Query().Get("service")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(doc))
+	}))
+	defer srv.Close()
+	set := func(s int, d string) {
+		mu.Lock()
+		status, doc = s, d
+		mu.Unlock()
+	}
+
+	s := newRemoteSampler(&RemoteSamplerConfig{URL: srv.URL + "/sampling"}, "trpc.app.server.Service")
+	defer s.Close()
+	assert.Eventually(t, func() bool { return s.sample(math.MaxUint64 - 1) }, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, "trpc.app.server.Service", service)
+	mu.Unlock()
+
+	set(http.StatusOK, `{"probabilisticSampling":{"samplingRate":0}}`)
+	assert.Nil(t, s.poll())
+	assert.False(t, s.sample(1))
+
+	// the last good strategy is kept on failure
+	set(http.StatusInternalServerError, `{"probabilisticSampling":{"samplingRate":1}}`)
+	assert.NotNil(t, s.poll())
+	assert.False(t, s.sample(1))
+	set(http.StatusOK, `{"probabilisticSampling":`)
+	assert.NotNil(t, s.poll())
+	assert.False(t, s.sample(1))
+	set(http.StatusOK, `{"probabilisticSampling":{"samplingRate":-1}}`)
+	assert.NotNil(t, s.poll())
+	assert.False(t, s.sample(1))
+}
+
+func Test_remoteSampler_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "strategies")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "strategies.json")
+
+	// the initial rate is used until a strategy is loaded
+	rate := 1.0
+	s := newRemoteSampler(&RemoteSamplerConfig{File: file, InitialRate: &rate}, "trpc.app.server.Service")
+	defer s.Close()
+	assert.True(t, s.sample(1))
+
+	assert.Nil(t, ioutil.WriteFile(file, []byte(`{"default_strategy":{"type":"probabilistic","param":0,`+
+		`"operation_strategies":[{"operation":"/a","type":"probabilistic","param":1}]}}`), 0644))
+	assert.Nil(t, s.poll())
+	assert.False(t, s.sample(1))
+	assert.True(t, s.sampleOperation(samplingOperation{name: "/a"}, 1))
+
+	assert.Nil(t, os.Remove(file))
+	assert.NotNil(t, s.poll())
+	assert.True(t, s.sampleOperation(samplingOperation{name: "/a"}, 1))
+}
+
+func TestConfig_RemoteSamplerClosedWithReporter(t *testing.T) {
+	rate := 0.0
+	c := &Config{
+		ServiceName: "trpc.app.server.Service",
+		Sampler: &SamplerConfig{
+			Type: AlwaysSampler,
+			Rules: []*SamplingRuleConfig{{
+				RPCName: "/a",
+				Sampler: &SamplerConfig{
+					Type:   RemoteSampler,
+					Remote: &RemoteSamplerConfig{File: "not-exist.json", InitialRate: &rate},
+				},
+			}},
+		},
+	}
+	sr := newSharedReporter(reporter.NewNoopReporter())
+	tracer, err := c.newOpenTracingTracer(sr)
+	assert.Nil(t, err)
+	s := tracer.(*operationSamplingTracer).sampler.(*ruleSampler).rules[0].opSampler.(*remoteSampler)
+
+	assert.Nil(t, sr.Close())
+	select {
+	case <-s.poller.stop:
+	default:
+		t.Fatal("remote sampler is not stopped")
+	}
+}
+
+func Test_remoteSampler_startup(t *testing.T) {
+	// an endpoint which never answers does not delay the sampler
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	rate := 1.0
+	start := time.Now()
+	s := newRemoteSampler(&RemoteSamplerConfig{URL: srv.URL, InitialRate: &rate}, "trpc.app.server.Service")
+	defer s.Close()
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, s.sample(1))
+}
+
+func Test_remoteSampler_sharedPoller(t *testing.T) {
+	dir, err := ioutil.TempDir("", "strategies")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "strategies.json")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(`{"default_strategy":{"type":"probabilistic","param":0},`+
+		`"service_strategies":[{"service":"trpc.app.server.Admin","type":"probabilistic","param":1}]}`), 0644))
+
+	c := &RemoteSamplerConfig{File: file}
+	public := newRemoteSampler(c, "trpc.app.server.Public")
+	admin := newRemoteSampler(c, "trpc.app.server.Admin")
+	assert.Equal(t, public.poller, admin.poller)
+	assert.Eventually(t, func() bool { return admin.sample(1) }, time.Second, 10*time.Millisecond)
+	assert.False(t, public.sample(1))
+
+	// samplers subscribing later get the loaded strategy at once
+	later := newRemoteSampler(c, "trpc.app.server.Admin")
+	assert.True(t, later.sample(1))
+
+	// the poller stops with the last sampler
+	assert.Nil(t, public.Close())
+	assert.Nil(t, later.Close())
+	select {
+	case <-admin.poller.stop:
+		t.Fatal("poller stopped while in use")
+	default:
+	}
+	assert.Nil(t, admin.Close())
+	<-admin.poller.stop
+
+	// samplers of other urls do not share the poller
+	other := newRemoteSampler(&RemoteSamplerConfig{URL: "http://127.0.0.1:1/sampling"}, "trpc.app.server.Admin")
+	defer other.Close()
+	assert.NotEqual(t, admin.poller, other.poller)
+}
+
+func TestConfig_ValidateRemoteSampler(t *testing.T) {
+	rate := 2.0
+	tests := []struct {
+		name string
+		cfg  *RemoteSamplerConfig
+		want []string
+	}{
+		{"missing", nil, []string{"sampler.remote"}},
+		{"url", &RemoteSamplerConfig{URL: "http://127.0.0.1:5778/sampling"}, nil},
+		{"file", &RemoteSamplerConfig{File: "strategies.json"}, nil},
+		{"none", &RemoteSamplerConfig{}, []string{"sampler.remote.url"}},
+		{"both", &RemoteSamplerConfig{URL: "http://127.0.0.1:5778", File: "strategies.json"}, []string{"sampler.remote.url"}},
+		{"scheme", &RemoteSamplerConfig{URL: "127.0.0.1:5778"}, []string{"sampler.remote.url"}},
+		{
+			"negative",
+			&RemoteSamplerConfig{File: "strategies.json", PollIntervalSeconds: -1, TimeoutSeconds: -1, InitialRate: &rate},
+			[]string{
+				"sampler.remote.poll_interval_seconds",
+				"sampler.remote.timeout_seconds",
+				"sampler.remote.initial_rate",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{
+				Sampler:  &SamplerConfig{Type: RemoteSampler, Remote: tt.cfg},
+				Reporter: &ReporterConfig{Type: NoopReporter},
+			}).Validate()
+			var fields []string
+			var errs ConfigErrors
+			if errors.As(err, &errs) {
+				for _, fe := range errs {
+					fields = append(fields, fe.Field)
+				}
+			}
+			assert.Equal(t, tt.want, fields)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+var (
+	reportersMu sync.Mutex
+	// reporters holds all reporters which have not been closed yet.
+	reporters = make(map[*sharedReporter]struct{})
+)
+
+// Shutdown flushes and closes every reporter created by this package, including
+// those of tracers built by Config.NewZipkinTracer. It returns ctx.Err() if ctx
+// is done before all reporters are closed.
+func Shutdown(ctx context.Context) error {
+	reportersMu.Lock()
+	rs := make([]*sharedReporter, 0, len(reporters))
+	for r := range reporters {
+		rs = append(rs, r)
+	}
+	reportersMu.Unlock()
+	return shutdownReporters(ctx, rs...)
+}
+
+// shutdownReporters closes rs concurrently and waits until all of them are
+// closed or ctx is done. The first close error is returned.
+func shutdownReporters(ctx context.Context, rs ...*sharedReporter) error {
+	errCh := make(chan error, len(rs))
+	for _, r := range rs {
+		go func(r *sharedReporter) {
+			errCh <- r.Close()
+		}(r)
+	}
+	var firstErr error
+	for range rs {
+		select {
+		case err := <-errCh:
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+// sharedReporter lets several tracers report through one underlying reporter,
+// which is closed once by Close, on plugin close, reload or Shutdown.
+type sharedReporter struct {
+	reporter reporter.Reporter
+
+	// mu is held for reading while a span is sent, so that no span is sent
+	// to the underlying reporter once closed is set.
+	mu     sync.RWMutex
+	closed bool
+	// closers are closed along with the underlying reporter, such as the
+	// samplers of the tracers reporting through it.
+	closers []io.Closer
+}
+
+func newSharedReporter(r reporter.Reporter) *sharedReporter {
+	sr := &sharedReporter{reporter: r}
+	reportersMu.Lock()
+	reporters[sr] = struct{}{}
+	reportersMu.Unlock()
+	return sr
+}
+
+// closeWith closes c along with the underlying reporter, or at once if the
+// reporter has been closed.
+func (r *sharedReporter) closeWith(c io.Closer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		_ = c.Close()
+		return
+	}
+	r.closers = append(r.closers, c)
+}
+
+// Send implements reporter.Reporter. Spans sent after the reporter
+// has been closed are dropped.
+func (r *sharedReporter) Send(s model.SpanModel) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	r.reporter.Send(s)
+}
+
+// Close implements reporter.Reporter. It flushes and closes the underlying
+// reporter without holding the lock, so that Send drops spans at once rather
+// than waiting for the flush.
+func (r *sharedReporter) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	closers := r.closers
+	r.closers = nil
+	r.mu.Unlock()
+
+	reportersMu.Lock()
+	delete(reporters, r)
+	reportersMu.Unlock()
+	for _, c := range closers {
+		_ = c.Close()
+	}
+	return r.reporter.Close()
+}
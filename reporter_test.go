@@ -0,0 +1,107 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type closeCountReporter struct {
+	sent   int
+	closed int
+}
+
+func (r *closeCountReporter) Send(model.SpanModel) { r.sent++ }
+func (r *closeCountReporter) Close() error         { r.closed++; return nil }
+
+type blockingReporter struct {
+	release chan struct{}
+}
+
+func (r *blockingReporter) Send(model.SpanModel) {}
+func (r *blockingReporter) Close() error         { <-r.release; return nil }
+
+func TestSharedReporter(t *testing.T) {
+	underlying := &closeCountReporter{}
+	r := newSharedReporter(underlying)
+
+	r.Send(model.SpanModel{})
+	r.Send(model.SpanModel{})
+	assert.Equal(t, 2, underlying.sent)
+
+	assert.Nil(t, r.Close())
+	assert.Equal(t, 1, underlying.closed)
+
+	// spans are dropped and closing is idempotent once closed
+	r.Send(model.SpanModel{})
+	assert.Equal(t, 2, underlying.sent)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, 1, underlying.closed)
+}
+
+func TestSharedReporter_SendWhileClosing(t *testing.T) {
+	underlying := &blockingReporter{release: make(chan struct{})}
+	r := newSharedReporter(underlying)
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(closed)
+	}()
+	assert.Eventually(t, func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.closed
+	}, time.Second, time.Millisecond)
+
+	// spans are dropped rather than waiting for the flush
+	sent := make(chan struct{})
+	go func() {
+		r.Send(model.SpanModel{})
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send blocked by close")
+	}
+	close(underlying.release)
+	<-closed
+}
+
+func TestShutdown(t *testing.T) {
+	underlying := &closeCountReporter{}
+	r := newSharedReporter(underlying)
+	assert.Nil(t, Shutdown(context.Background()))
+	assert.Equal(t, 1, underlying.closed)
+	assert.NotContains(t, reporters, r)
+
+	r.Send(model.SpanModel{})
+	assert.Equal(t, 0, underlying.sent)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, 1, underlying.closed)
+}
+
+func TestShutdown_Timeout(t *testing.T) {
+	underlying := &blockingReporter{release: make(chan struct{})}
+	defer close(underlying.release)
+	r := newSharedReporter(underlying)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, shutdownReporters(ctx, r))
+}
@@ -0,0 +1,220 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// maxSampledOperations bounds the number of operations with a guaranteed
+// sampling rate, so that high cardinality operation names cannot exhaust memory.
+const maxSampledOperations = 2000
+
+// samplingOperation describes the root span of a new trace to samplers.
+type samplingOperation struct {
+	service string // the local service
+	name    string // the rpc name
+	caller  string // the caller service of a server span, empty otherwise
+}
+
+// operationSampler decides whether to sample a new trace knowing the operation
+// of its root span, which zipkin.Sampler is not given.
+type operationSampler interface {
+	sampleOperation(op samplingOperation, id uint64) bool
+}
+
+// rateLimiter is a token bucket holding up to max(perSecond, 1) tokens. It is
+// implemented as GCRA, whose state is a single theoretical arrival time that
+// is updated with CAS, so allow never locks.
+type rateLimiter struct {
+	tat      int64 // theoretical arrival time of the next token in unix nanoseconds
+	interval int64 // nanoseconds to refill one token
+	burst    int64 // nanoseconds to refill the full bucket
+	now      func() int64
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	interval := float64(time.Second) / perSecond
+	return &rateLimiter{
+		interval: int64(interval),
+		burst:    int64(math.Max(perSecond, 1) * interval),
+		now:      func() int64 { return time.Now().UnixNano() },
+	}
+}
+
+// allow takes a token, it returns false if the bucket is empty.
+func (l *rateLimiter) allow() bool {
+	now := l.now()
+	for {
+		tat := atomic.LoadInt64(&l.tat)
+		next := tat
+		if next < now {
+			next = now
+		}
+		next += l.interval
+		if next-now > l.burst {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.tat, tat, next) {
+			return true
+		}
+	}
+}
+
+// rateLimitingSampler samples at most tracesPerSecond traces per second. In the
+// hybrid mode each operation is sampled at least minPerOperation times per
+// second, and the other traces are sampled with probability, which is still
+// bounded by tracesPerSecond if it is set.
+type rateLimitingSampler struct {
+	limiter         *rateLimiter // nil if the number of traces is not limited
+	minPerOperation float64
+	probability     zipkin.Sampler
+
+	operations    sync.Map // operation name => *rateLimiter
+	numOperations int32
+}
+
+func newRateLimitingSampler(c *RateLimitingSamplerConfig) (*rateLimitingSampler, error) {
+	s := &rateLimitingSampler{minPerOperation: c.MinPerSecondPerOperation}
+	if c.TracesPerSecond > 0 {
+		s.limiter = newRateLimiter(c.TracesPerSecond)
+	}
+	if s.hybrid() {
+		var err error
+		if s.probability, err = zipkin.NewBoundarySampler(c.Probability, 0); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *rateLimitingSampler) hybrid() bool {
+	return s.minPerOperation > 0
+}
+
+// sample implements zipkin.Sampler, all traces are taken as one operation.
+func (s *rateLimitingSampler) sample(id uint64) bool {
+	return s.sampleOperation(samplingOperation{}, id)
+}
+
+func (s *rateLimitingSampler) sampleOperation(op samplingOperation, id uint64) bool {
+	if s.hybrid() {
+		if l := s.operationLimiter(op.name); l != nil && l.allow() {
+			return true
+		}
+		if !s.probability(id) {
+			return false
+		}
+	}
+	return s.limiter == nil || s.limiter.allow()
+}
+
+// operationLimiter returns the limiter guaranteeing the samples of operation, or
+// nil if there are too many operations.
+func (s *rateLimitingSampler) operationLimiter(operation string) *rateLimiter {
+	if l, ok := s.operations.Load(operation); ok {
+		return l.(*rateLimiter)
+	}
+	if atomic.AddInt32(&s.numOperations, 1) > maxSampledOperations {
+		atomic.AddInt32(&s.numOperations, -1)
+		return nil
+	}
+	l, loaded := s.operations.LoadOrStore(operation, newRateLimiter(s.minPerOperation))
+	if loaded {
+		atomic.AddInt32(&s.numOperations, -1)
+	}
+	return l.(*rateLimiter)
+}
+
+// operationSamplingTracer makes the sampling decision of new traces with
+// operationSampler before the zipkin tracer starts their root spans.
+type operationSamplingTracer struct {
+	opentracing.Tracer
+	sampler operationSampler
+	// service is the service name of the tracer, used if the operation has none
+	service string
+}
+
+// StartSpan implements opentracing.Tracer.
+func (t *operationSamplingTracer) StartSpan(operationName string,
+	opts ...opentracing.StartSpanOption) opentracing.Span {
+	return t.startSpan(samplingOperation{name: operationName}, opts...)
+}
+
+// startSpan starts a span of op with tracer. Tracers with operationSampler
+// decide new traces by op, the others by trace id only.
+func startSpan(tracer opentracing.Tracer, op samplingOperation,
+	opts ...opentracing.StartSpanOption) opentracing.Span {
+	if t, ok := tracer.(*operationSamplingTracer); ok {
+		return t.startSpan(op, opts...)
+	}
+	return tracer.StartSpan(op.name, opts...)
+}
+
+func (t *operationSamplingTracer) startSpan(op samplingOperation,
+	opts ...opentracing.StartSpanOption) opentracing.Span {
+	if op.service == "" {
+		op.service = t.service
+	}
+	var o opentracing.StartSpanOptions
+	for _, opt := range opts {
+		opt.Apply(&o)
+	}
+	var parent model.SpanContext
+	if len(o.References) > 0 {
+		sc, ok := o.References[0].ReferencedContext.(zipkinOpentracing.SpanContext)
+		if !ok {
+			return t.Tracer.StartSpan(op.name, opts...)
+		}
+		parent = model.SpanContext(sc)
+	}
+	if parent.Debug || parent.Sampled != nil {
+		return t.Tracer.StartSpan(op.name, opts...)
+	}
+	// the trace id of a root span is not generated yet
+	id := parent.TraceID.Low
+	if parent.TraceID.Empty() {
+		id = rand.Uint64()
+	}
+	sampled := t.sampler.sampleOperation(op, id)
+	parent.Sampled = &sampled
+	return t.Tracer.StartSpan(op.name, append(opts, parentOption(parent))...)
+}
+
+// parentOption replaces the parent of a span. A parent without trace id only
+// carries the sampling decision to the root span.
+type parentOption model.SpanContext
+
+// Apply implements opentracing.StartSpanOption.
+func (p parentOption) Apply(o *opentracing.StartSpanOptions) {
+	ref := opentracing.SpanReference{
+		Type:              opentracing.ChildOfRef,
+		ReferencedContext: zipkinOpentracing.SpanContext(p),
+	}
+	if len(o.References) == 0 {
+		o.References = append(o.References, ref)
+		return
+	}
+	ref.Type = o.References[0].Type
+	o.References[0] = ref
+}
@@ -0,0 +1,133 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// Matches of the rpc name of sampling rules.
+const (
+	ExactMatch  = "exact"
+	PrefixMatch = "prefix"
+	GlobMatch   = "glob"
+)
+
+// checkGlob returns path.ErrBadPattern if pattern is malformed.
+func checkGlob(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// samplingRule selects its sampler for the operations it matches.
+type samplingRule struct {
+	service string
+	rpcName string
+	match   string
+	caller  string
+
+	sampler   zipkin.Sampler
+	opSampler operationSampler // nil if sampler does not decide by operation
+}
+
+func (r *samplingRule) matches(op samplingOperation) bool {
+	if r.service != "" && r.service != op.service {
+		return false
+	}
+	if r.caller != "" && r.caller != op.caller {
+		return false
+	}
+	if r.rpcName == "" {
+		return true
+	}
+	switch r.match {
+	case PrefixMatch:
+		return strings.HasPrefix(op.name, r.rpcName)
+	case GlobMatch:
+		ok, _ := path.Match(r.rpcName, op.name)
+		return ok
+	default:
+		return r.rpcName == op.name
+	}
+}
+
+func (r *samplingRule) sampleOperation(op samplingOperation, id uint64) bool {
+	if r.opSampler != nil {
+		return r.opSampler.sampleOperation(op, id)
+	}
+	return r.sampler(id)
+}
+
+// ruleSampler samples a new trace with the first rule matching its operation,
+// or with the default rule if none matches.
+type ruleSampler struct {
+	rules []*samplingRule
+	// defaultRule matches every operation
+	defaultRule *samplingRule
+	// service is the service of the tracer, which is taken as the service of
+	// traces sampled by trace id only
+	service string
+}
+
+func newRuleSampler(c *SamplerConfig, service string) (*ruleSampler, error) {
+	s := &ruleSampler{service: service, defaultRule: &samplingRule{}}
+	var err error
+	if s.defaultRule.sampler, s.defaultRule.opSampler, err = c.newSampler(service); err != nil {
+		return nil, err
+	}
+	for _, rc := range c.Rules {
+		r := &samplingRule{
+			service: rc.Service,
+			rpcName: rc.RPCName,
+			match:   rc.Match,
+			caller:  rc.Caller,
+		}
+		if r.sampler, r.opSampler, err = rc.Sampler.newSampler(service); err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, r)
+	}
+	return s, nil
+}
+
+// sample implements zipkin.Sampler.
+func (s *ruleSampler) sample(id uint64) bool {
+	return s.sampleOperation(samplingOperation{service: s.service}, id)
+}
+
+func (s *ruleSampler) sampleOperation(op samplingOperation, id uint64) bool {
+	for _, r := range s.rules {
+		if r.matches(op) {
+			return r.sampleOperation(op, id)
+		}
+	}
+	return s.defaultRule.sampleOperation(op, id)
+}
+
+// Close closes the samplers of the rules which need closing.
+func (s *ruleSampler) Close() error {
+	for _, r := range s.rules {
+		if c, ok := r.opSampler.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+	if c, ok := s.defaultRule.opSampler.(io.Closer); ok {
+		_ = c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+func Test_samplingRule_matches(t *testing.T) {
+	op := samplingOperation{
+		service: "trpc.app.server.Service",
+		name:    "/trpc.app.server.Service/HealthCheck",
+		caller:  "trpc.app.gateway.Service",
+	}
+	tests := []struct {
+		name string
+		rule samplingRule
+		want bool
+	}{
+		{"empty", samplingRule{}, true},
+		{"exact", samplingRule{rpcName: "/trpc.app.server.Service/HealthCheck"}, true},
+		{"exact mismatch", samplingRule{rpcName: "/trpc.app.server.Service/Health"}, false},
+		{"prefix", samplingRule{rpcName: "/trpc.app.server.Service/Health", match: PrefixMatch}, true},
+		{"glob", samplingRule{rpcName: "/*/Health*", match: GlobMatch}, true},
+		{"glob mismatch", samplingRule{rpcName: "/*/Hello", match: GlobMatch}, false},
+		{"service", samplingRule{service: "trpc.app.server.Service"}, true},
+		{"service mismatch", samplingRule{service: "trpc.app.admin.Service"}, false},
+		{"caller", samplingRule{caller: "trpc.app.gateway.Service"}, true},
+		{"caller mismatch", samplingRule{caller: "trpc.app.other.Service"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rule.matches(op))
+		})
+	}
+}
+
+func Test_ruleSampler(t *testing.T) {
+	s, err := newRuleSampler(&SamplerConfig{
+		Type: AlwaysSampler,
+		Rules: []*SamplingRuleConfig{
+			{RPCName: "/trpc.health.Health/", Match: PrefixMatch, Sampler: &SamplerConfig{Type: NeverSampler}},
+			{Caller: "trpc.app.batch.Service", Sampler: &SamplerConfig{Type: NeverSampler}},
+			{Service: "trpc.app.server.Service", Sampler: &SamplerConfig{
+				Type:         RateLimitingSampler,
+				RateLimiting: &RateLimitingSamplerConfig{MinPerSecondPerOperation: 1},
+			}},
+		},
+	}, "trpc.app.server.Service")
+	assert.Nil(t, err)
+
+	assert.False(t, s.sampleOperation(samplingOperation{name: "/trpc.health.Health/Check"}, 1))
+	assert.False(t, s.sampleOperation(samplingOperation{name: "/a", caller: "trpc.app.batch.Service"}, 1))
+	assert.True(t, s.sampleOperation(samplingOperation{name: "/a", service: "trpc.app.other.Service"}, 1))
+	// the rule of the service guarantees one sample per operation
+	op := samplingOperation{name: "/a", service: "trpc.app.server.Service"}
+	assert.True(t, s.sampleOperation(op, 1))
+	assert.False(t, s.sampleOperation(op, 2))
+	// traces sampled by id only are taken as operations of the tracer service
+	assert.True(t, s.sample(1))
+	assert.False(t, s.sample(2))
+}
+
+func TestServerFilter_SamplingRules(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{
+		ServiceName: "trpc.app.server.Service",
+		Sampler: &SamplerConfig{
+			Type: AlwaysSampler,
+			Rules: []*SamplingRuleConfig{{
+				RPCName: "/trpc.app.server.Service/Heartbeat",
+				Sampler: &SamplerConfig{Type: NeverSampler},
+			}},
+		},
+	}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	z := &zipkinPlugin{tracers: map[string]opentracing.Tracer{"trpc.app.server.Service": tracer}}
+
+	for _, name := range []string{"/trpc.app.server.Service/Heartbeat", "/trpc.app.server.Service/Hello"} {
+		ctx, msg := codec.WithNewMessage(context.Background())
+		msg.WithCalleeServiceName("trpc.app.server.Service")
+		msg.WithServerRPCName(name)
+		_, err := ServerFilter(z)(ctx, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		assert.Nil(t, err)
+	}
+	spans := rec.Flush()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "/trpc.app.server.Service/Hello", spans[0].Name)
+}
+
+func TestConfig_ValidateSamplingRules(t *testing.T) {
+	err := (&Config{
+		Sampler: &SamplerConfig{
+			Type: AlwaysSampler,
+			Rules: []*SamplingRuleConfig{
+				{RPCName: "/a", Sampler: &SamplerConfig{Type: NeverSampler}},
+				{RPCName: "[", Match: GlobMatch, Sampler: &SamplerConfig{Type: NeverSampler}},
+				{Match: "regexp"},
+				{Sampler: &SamplerConfig{Type: NeverSampler, Rules: []*SamplingRuleConfig{{}}}},
+				{Sampler: &SamplerConfig{Type: CountingSampler}},
+			},
+		},
+		Reporter: &ReporterConfig{Type: NoopReporter},
+	}).Validate()
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	var fields []string
+	for _, fe := range errs {
+		fields = append(fields, fe.Field)
+	}
+	assert.Equal(t, []string{
+		"sampler.rules[1].rpc_name",
+		"sampler.rules[2].match",
+		"sampler.rules[2].sampler",
+		"sampler.rules[3].sampler.rules",
+		"sampler.rules[4].sampler.counting",
+	}, fields)
+}
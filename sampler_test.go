@@ -0,0 +1,191 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now int64
+}
+
+func (c *fakeClock) Now() int64 { return c.now }
+
+func (c *fakeClock) Add(d time.Duration) { c.now += int64(d) }
+
+func Test_rateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond float64
+		wait      time.Duration
+		want      []bool
+	}{
+		{"burst", 3, 0, []bool{true, true, true, false}},
+		{"refill", 2, 500 * time.Millisecond, []bool{true, true, true, true, true}},
+		{"fraction", 0.5, time.Second, []bool{true, false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now().UnixNano()}
+			l := newRateLimiter(tt.perSecond)
+			l.now = clock.Now
+			var got []bool
+			for range tt.want {
+				got = append(got, l.allow())
+				clock.Add(tt.wait)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_rateLimiter_concurrent(t *testing.T) {
+	l := newRateLimiter(100)
+	l.now = (&fakeClock{now: time.Now().UnixNano()}).Now
+	var allowed int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if l.allow() {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), allowed)
+}
+
+func Test_rateLimitingSampler(t *testing.T) {
+	s, err := newRateLimitingSampler(&RateLimitingSamplerConfig{TracesPerSecond: 2})
+	assert.Nil(t, err)
+	assert.False(t, s.hybrid())
+	assert.True(t, s.sample(1))
+	assert.True(t, s.sample(2))
+	assert.False(t, s.sample(3))
+
+	// one sample per operation, then never by probability
+	s, err = newRateLimitingSampler(&RateLimitingSamplerConfig{MinPerSecondPerOperation: 1})
+	assert.Nil(t, err)
+	assert.True(t, s.hybrid())
+	assert.True(t, s.sampleOperation(samplingOperation{name: "a"}, 1))
+	assert.False(t, s.sampleOperation(samplingOperation{name: "a"}, 2))
+	assert.True(t, s.sampleOperation(samplingOperation{name: "b"}, 3))
+
+	// always by probability, bounded by the rate limit
+	s, err = newRateLimitingSampler(&RateLimitingSamplerConfig{
+		TracesPerSecond:          1,
+		MinPerSecondPerOperation: 1,
+		Probability:              1,
+	})
+	assert.Nil(t, err)
+	assert.True(t, s.sampleOperation(samplingOperation{name: "a"}, 1))
+	assert.True(t, s.sampleOperation(samplingOperation{name: "a"}, 2))
+	assert.False(t, s.sampleOperation(samplingOperation{name: "a"}, 3))
+
+	// operations beyond the limit have no guaranteed samples
+	s, err = newRateLimitingSampler(&RateLimitingSamplerConfig{MinPerSecondPerOperation: 1})
+	assert.Nil(t, err)
+	s.numOperations = maxSampledOperations
+	assert.False(t, s.sampleOperation(samplingOperation{name: "c"}, 1))
+}
+
+func Test_operationSamplingTracer(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{
+		ServiceName: "trpc.app.server.Service",
+		Sampler: &SamplerConfig{
+			Type:         RateLimitingSampler,
+			RateLimiting: &RateLimitingSamplerConfig{MinPerSecondPerOperation: 1},
+		},
+	}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	assert.IsType(t, &operationSamplingTracer{}, tracer)
+
+	for _, name := range []string{"/a", "/a", "/b"} {
+		span := tracer.StartSpan(name)
+		child := tracer.StartSpan(name+"/child", opentracing.ChildOf(span.Context()))
+		child.Finish()
+		span.Finish()
+	}
+	var names []string
+	for _, span := range rec.Flush() {
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{"/a/child", "/a", "/b/child", "/b"}, names)
+
+	// deferred decisions of upstream are made by operation too
+	sc := newTestSpanContext(true)
+	sc.Sampled = nil
+	span := tracer.StartSpan("/c", opentracing.FollowsFrom(zipkinOpentracing.SpanContext(sc)))
+	span.Finish()
+	spans := rec.Flush()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, sc.TraceID, spans[0].TraceID)
+	assert.Equal(t, sc.ID, *spans[0].ParentID)
+}
+
+func TestConfig_ValidateRateLimiting(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *RateLimitingSamplerConfig
+		want []string
+	}{
+		{"missing", nil, []string{"sampler.ratelimiting"}},
+		{"limit", &RateLimitingSamplerConfig{TracesPerSecond: 0.1}, nil},
+		{"hybrid", &RateLimitingSamplerConfig{MinPerSecondPerOperation: 1, Probability: 0.01}, nil},
+		{"zero", &RateLimitingSamplerConfig{}, []string{"sampler.ratelimiting.traces_per_second"}},
+		{
+			"negative",
+			&RateLimitingSamplerConfig{TracesPerSecond: -1, MinPerSecondPerOperation: -1, Probability: 2},
+			[]string{
+				"sampler.ratelimiting.traces_per_second",
+				"sampler.ratelimiting.min_per_second_per_operation",
+				"sampler.ratelimiting.probability",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{
+				Sampler:  &SamplerConfig{Type: RateLimitingSampler, RateLimiting: tt.cfg},
+				Reporter: &ReporterConfig{Type: NoopReporter},
+			}).Validate()
+			var fields []string
+			var errs ConfigErrors
+			if errors.As(err, &errs) {
+				for _, fe := range errs {
+					fields = append(fields, fe.Field)
+				}
+			}
+			assert.Equal(t, tt.want, fields)
+		})
+	}
+}
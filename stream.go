@@ -0,0 +1,346 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	traceLog "github.com/opentracing/opentracing-go/log"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go/model"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/server"
+)
+
+// Tags set on stream spans.
+const (
+	TagStreamType       = "trpc.stream_type"
+	TagMessagesSent     = "trpc.stream.messages_sent"
+	TagMessagesReceived = "trpc.stream.messages_received"
+)
+
+// StreamServerFilter returns a distributed tracing filter for RPC server streams.
+// One span covers the whole stream and every message is recorded as an annotation.
+func StreamServerFilter(z *zipkinPlugin) server.StreamFilter {
+	return func(ss server.Stream, info *server.StreamServerInfo, handler server.StreamHandler) error {
+		ctx := ss.Context()
+		msg := codec.Message(ctx)
+
+		tracer := z.tracers[msg.CalleeServiceName()]
+		if tracer == nil {
+			tracer = opentracing.GlobalTracer()
+		}
+
+		// the context is carried in the metadata of the init frame
+		tc, found := z.extract(metadataTextMap(msg.ServerMetaData()), msg)
+		var parentSpanContext opentracing.SpanContext
+		if found {
+			parentSpanContext = zipkinOpentracing.SpanContext(tc.spanContext)
+		}
+		op := serverOperation(msg)
+		if op.name == "" {
+			op.name = info.FullMethod
+		}
+		streamTypeTag := opentracing.Tag{Key: TagStreamType, Value: streamType(info.IsClientStream, info.IsServerStream)}
+		var serverSpan opentracing.Span = startSpan(tracer, op, ext.RPCServerOption(parentSpanContext), streamTypeTag)
+		serverSpan = z.sampleErrors(tracer, serverSpan, op.name, ext.SpanKindRPCServer, streamTypeTag)
+		tagExternal(serverSpan, tc)
+		z.baggage.restrict(tc)
+		z.baggage.tag(serverSpan, tc)
+		serverSpan = &baggageSpan{Span: serverSpan, tc: tc}
+
+		ctx = opentracing.ContextWithSpan(ctx, serverSpan)
+		ctx = contextWithTraceContext(ctx, tc)
+		s := &serverStream{Stream: ss, ctx: ctx, span: serverSpan}
+
+		err := handler(s)
+		if err != nil {
+			ext.Error.Set(serverSpan, true)
+			serverSpan.LogFields(traceLog.String("event", "error"), traceLog.String("message", err.Error()))
+		}
+		s.messages.tag(serverSpan)
+		setRPCTags(serverSpan, msg, false, err)
+		setResultTags(serverSpan, nil, nil, err)
+		serverSpan.Finish()
+		return err
+	}
+}
+
+// StreamClientFilter returns a distributed tracing filter for RPC client streams.
+// The span is finished when the stream ends, fails or its context is done.
+func StreamClientFilter(z *zipkinPlugin) client.StreamFilter {
+	return func(ctx context.Context, desc *client.ClientStreamDesc, streamer client.Streamer) (client.ClientStream, error) {
+		var parentSpanCtx opentracing.SpanContext
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			parentSpanCtx = parent.Context()
+		}
+
+		msg := codec.Message(ctx)
+		opts := []opentracing.StartSpanOption{
+			opentracing.ChildOf(parentSpanCtx),
+			ext.SpanKindRPCClient,
+			opentracing.Tag{Key: TagStreamType, Value: streamType(desc.ClientStreams, desc.ServerStreams)},
+		}
+		if callee := msg.CalleeServiceName(); callee != "" {
+			opts = append(opts, opentracing.Tag{Key: string(ext.PeerService), Value: callee})
+		}
+		op := samplingOperation{service: msg.CallerServiceName(), name: msg.ClientRPCName()}
+		if op.name == "" {
+			op.name = desc.StreamName
+		}
+		tracer := z.clientTracer(ctx, msg)
+		clientSpan := z.sampleErrors(tracer, startSpan(tracer, op, opts...), op.name, opts...)
+
+		// the metadata of msg is sent in the init frame
+		md := msg.ClientMetaData().Clone()
+		if md == nil {
+			md = codec.MetaData{}
+		}
+		if sc, ok := clientSpan.Context().(zipkinOpentracing.SpanContext); ok {
+			tc := traceContextFromContext(ctx).child(model.SpanContext(sc))
+			z.baggage.restrict(tc)
+			z.baggage.tag(clientSpan, tc)
+			z.propagation().inject(tc, metadataTextMap(md))
+		}
+		msg.WithClientMetaData(md)
+		ctx = opentracing.ContextWithSpan(ctx, clientSpan)
+
+		s := &clientStream{
+			span:          clientSpan,
+			msg:           msg,
+			serverStreams: desc.ServerStreams,
+			linger:        streamLinger,
+			done:          make(chan struct{}),
+		}
+		cs, err := streamer(ctx, desc)
+		if err != nil {
+			s.finish(err)
+			return nil, err
+		}
+		s.ClientStream = cs
+		if ctx.Done() != nil {
+			go func() {
+				select {
+				case <-ctx.Done():
+					s.finish(ctx.Err())
+				case <-s.done:
+				}
+			}()
+		}
+		return s, nil
+	}
+}
+
+// streamType describes a stream by the sides sending multiple messages.
+func streamType(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return "bidi"
+	case clientStreams:
+		return "client"
+	case serverStreams:
+		return "server"
+	default:
+		return "unary"
+	}
+}
+
+// maxMessageLogs bounds the message annotations of a stream span, the totals
+// are tagged when the stream ends.
+const maxMessageLogs = 100
+
+// messageCounter records the messages of a stream on its span.
+type messageCounter struct {
+	sent     int64
+	received int64
+	logged   int64
+}
+
+func (c *messageCounter) onSend(span opentracing.Span, m interface{}) {
+	c.log(span, "sent", atomic.AddInt64(&c.sent, 1), m)
+}
+
+func (c *messageCounter) onRecv(span opentracing.Span, m interface{}) {
+	c.log(span, "received", atomic.AddInt64(&c.received, 1), m)
+}
+
+func (c *messageCounter) tag(span opentracing.Span) {
+	span.SetTag(TagMessagesSent, atomic.LoadInt64(&c.sent))
+	span.SetTag(TagMessagesReceived, atomic.LoadInt64(&c.received))
+}
+
+// log annotates span with the first messages of a reported stream, like
+// "message.sent:id=1 size=5".
+func (c *messageCounter) log(span opentracing.Span, event string, id int64, m interface{}) {
+	if !reported(span, nil) || atomic.AddInt64(&c.logged, 1) > maxMessageLogs {
+		return
+	}
+	v := fmt.Sprintf("id=%d", id)
+	if size, ok := bodySize(m); ok {
+		v += fmt.Sprintf(" size=%d", size)
+	}
+	span.LogFields(traceLog.String("message."+event, v))
+}
+
+// serverStream carries the context with the stream span to the handler.
+type serverStream struct {
+	messages messageCounter // first for the 64-bit alignment of atomic counters
+	server.Stream
+	ctx  context.Context
+	span opentracing.Span
+}
+
+// Context implements server.Stream.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg implements server.Stream.
+func (s *serverStream) SendMsg(m interface{}) error {
+	if err := s.Stream.SendMsg(m); err != nil {
+		return err
+	}
+	s.messages.onSend(s.span, m)
+	return nil
+}
+
+// RecvMsg implements server.Stream.
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if err := s.Stream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.messages.onRecv(s.span, m)
+	return nil
+}
+
+// streamLinger is how long the span of a client stream is kept open after
+// CloseSend while the caller is not receiving, as callers may stop reading.
+const streamLinger = 10 * time.Second
+
+// clientStream finishes the stream span once the stream is over.
+type clientStream struct {
+	messages messageCounter // first for the 64-bit alignment of atomic counters
+	client.ClientStream
+	span          opentracing.Span
+	msg           codec.Msg
+	serverStreams bool
+	linger        time.Duration
+	once          sync.Once
+	done          chan struct{}
+
+	// mu guards the state deciding whether the caller is done with the
+	// stream after CloseSend.
+	mu         sync.Mutex
+	sendClosed bool
+	receiving  int
+	idle       *time.Timer
+}
+
+// SendMsg implements client.ClientStream.
+func (s *clientStream) SendMsg(m interface{}) error {
+	if err := s.ClientStream.SendMsg(m); err != nil {
+		s.finish(err)
+		return err
+	}
+	s.messages.onSend(s.span, m)
+	return nil
+}
+
+// RecvMsg implements client.ClientStream. The stream is over when io.EOF is
+// received, or after the only response if the server does not stream.
+func (s *clientStream) RecvMsg(m interface{}) error {
+	s.mu.Lock()
+	s.receiving++
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.receiving--
+		s.waitIdleLocked()
+		s.mu.Unlock()
+	}()
+
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	default:
+		s.messages.onRecv(s.span, m)
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+	}
+	return err
+}
+
+// CloseSend implements client.ClientStream. The span is finished if the caller
+// does not receive within linger afterwards, at the time of its last call.
+func (s *clientStream) CloseSend() error {
+	if err := s.ClientStream.CloseSend(); err != nil {
+		s.finish(err)
+		return err
+	}
+	s.mu.Lock()
+	s.sendClosed = true
+	s.waitIdleLocked()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *clientStream) waitIdleLocked() {
+	if !s.sendClosed || s.receiving > 0 {
+		return
+	}
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	last := time.Now()
+	s.idle = time.AfterFunc(s.linger, func() { s.finishAt(nil, last) })
+}
+
+func (s *clientStream) finish(err error) {
+	s.finishAt(err, time.Time{})
+}
+
+// finishAt finishes the span at t, or now if t is zero.
+func (s *clientStream) finishAt(err error, t time.Time) {
+	s.once.Do(func() {
+		close(s.done)
+		if err != nil {
+			ext.Error.Set(s.span, true)
+			s.span.LogFields(traceLog.String("event", "error"), traceLog.String("message", err.Error()))
+		}
+		s.messages.tag(s.span)
+		setRPCTags(s.span, s.msg, true, err)
+		setResultTags(s.span, nil, nil, err)
+		if reported(s.span, err) {
+			setRemoteEndpoint(s.span, peerEndpoint(s.msg.CalleeServiceName(), s.msg.RemoteAddr()))
+		}
+		s.span.FinishWithOptions(opentracing.FinishOptions{FinishTime: t})
+	})
+}
@@ -0,0 +1,275 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/server"
+)
+
+// fakeStream serves both as server.Stream and client.ClientStream, receiving
+// the queued messages followed by recvErr.
+type fakeStream struct {
+	ctx     context.Context
+	recv    [][]byte
+	recvErr error
+	sendErr error
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStream) SendMsg(m interface{}) error { return s.sendErr }
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	if len(s.recv) == 0 {
+		return s.recvErr
+	}
+	*(m.(*[]byte)) = s.recv[0]
+	s.recv = s.recv[1:]
+	return nil
+}
+
+func (s *fakeStream) CloseSend() error { return nil }
+
+func newStreamTestPlugin(t *testing.T, rec *recorder.ReporterRecorder) *zipkinPlugin {
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	return &zipkinPlugin{tracers: map[string]opentracing.Tracer{"trpc.app.server.Service": tracer}}
+}
+
+func newStreamTestContext() (context.Context, codec.Msg) {
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCallerServiceName("trpc.app.server.Service")
+	msg.WithCalleeServiceName("trpc.app.backend.Service")
+	return ctx, msg
+}
+
+func TestStreamServerFilter(t *testing.T) {
+	rec := recorder.NewReporter()
+	z := newStreamTestPlugin(t, rec)
+
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCalleeServiceName("trpc.app.server.Service")
+	msg.WithServerRPCName("/trpc.app.server.Service/Chat")
+	msg.WithServerMetaData(codec.MetaData{
+		"x-b3-traceid": []byte("4bf92f3577b34da6a3ce929d0e0e4736"),
+		"x-b3-spanid":  []byte("00f067aa0ba902b7"),
+		"x-b3-sampled": []byte("1"),
+	})
+	ss := &fakeStream{ctx: ctx, recv: [][]byte{[]byte("hello"), []byte("hi")}, recvErr: io.EOF}
+	info := &server.StreamServerInfo{FullMethod: "/trpc.app.server.Service/Chat", IsClientStream: true, IsServerStream: true}
+
+	handler := func(ss server.Stream) error {
+		assert.NotNil(t, opentracing.SpanFromContext(ss.Context()))
+		var m []byte
+		for {
+			if err := ss.RecvMsg(&m); err == io.EOF {
+				break
+			}
+			if err := ss.SendMsg(m); err != nil {
+				return err
+			}
+		}
+		return errors.New("closed")
+	}
+	err := StreamServerFilter(z)(ss, info, handler)
+	assert.EqualError(t, err, "closed")
+
+	spans := rec.Flush()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, model.Server, span.Kind)
+	assert.Equal(t, "/trpc.app.server.Service/Chat", span.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String())
+	assert.Equal(t, "bidi", span.Tags[TagStreamType])
+	assert.Equal(t, "2", span.Tags[TagMessagesSent])
+	assert.Equal(t, "2", span.Tags[TagMessagesReceived])
+	assert.Equal(t, "true", span.Tags["error"])
+	var annotations []string
+	for _, a := range span.Annotations {
+		annotations = append(annotations, a.Value)
+	}
+	assert.Equal(t, []string{
+		"message.received:id=1 size=5",
+		"message.sent:id=1 size=5",
+		"message.received:id=2 size=2",
+		"message.sent:id=2 size=2",
+		"event:error",
+		"message:closed",
+	}, annotations)
+}
+
+func TestStreamServerFilter_MessageLogs(t *testing.T) {
+	tests := []struct {
+		sampled  string
+		wantLogs int
+	}{
+		{"1", maxMessageLogs},
+		// unsampled streams reported for the error only carry the error
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		t.Run("sampled="+tt.sampled, func(t *testing.T) {
+			rec := recorder.NewReporter()
+			z := newStreamTestPlugin(t, rec)
+			z.errorSampling = true
+			ctx, msg := codec.WithNewMessage(context.Background())
+			msg.WithCalleeServiceName("trpc.app.server.Service")
+			msg.WithServerMetaData(codec.MetaData{
+				"x-b3-traceid": []byte("4bf92f3577b34da6a3ce929d0e0e4736"),
+				"x-b3-spanid":  []byte("00f067aa0ba902b7"),
+				"x-b3-sampled": []byte(tt.sampled),
+			})
+			info := &server.StreamServerInfo{FullMethod: "/trpc.app.server.Service/Watch", IsServerStream: true}
+			handler := func(ss server.Stream) error {
+				for i := 0; i < 2*maxMessageLogs; i++ {
+					assert.Nil(t, ss.SendMsg([]byte("event")))
+				}
+				return errors.New("closed")
+			}
+			assert.NotNil(t, StreamServerFilter(z)(&fakeStream{ctx: ctx}, info, handler))
+
+			spans := rec.Flush()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, fmt.Sprint(2*maxMessageLogs), spans[0].Tags[TagMessagesSent])
+			assert.Len(t, spans[0].Annotations, tt.wantLogs+2)
+		})
+	}
+}
+
+func TestStreamClientFilter(t *testing.T) {
+	t.Run("eof", func(t *testing.T) {
+		rec := recorder.NewReporter()
+		z := newStreamTestPlugin(t, rec)
+		ctx, msg := newStreamTestContext()
+		msg.WithClientRPCName("/trpc.app.backend.Service/Watch")
+
+		var initMD codec.MetaData
+		streamer := func(ctx context.Context, desc *client.ClientStreamDesc) (client.ClientStream, error) {
+			initMD = codec.Message(ctx).ClientMetaData()
+			return &fakeStream{ctx: ctx, recv: [][]byte{[]byte("event")}, recvErr: io.EOF}, nil
+		}
+		cs, err := StreamClientFilter(z)(ctx, &client.ClientStreamDesc{ServerStreams: true}, streamer)
+		assert.Nil(t, err)
+		assert.Nil(t, cs.SendMsg([]byte("watch")))
+		assert.Empty(t, rec.Flush())
+
+		var m []byte
+		assert.Nil(t, cs.RecvMsg(&m))
+		assert.Equal(t, io.EOF, cs.RecvMsg(&m))
+
+		spans := rec.Flush()
+		assert.Len(t, spans, 1)
+		span := spans[0]
+		assert.Equal(t, model.Client, span.Kind)
+		assert.Equal(t, "server", span.Tags[TagStreamType])
+		assert.Equal(t, "1", span.Tags[TagMessagesSent])
+		assert.Equal(t, "1", span.Tags[TagMessagesReceived])
+		assert.Equal(t, "trpc.app.backend.Service", span.RemoteEndpoint.ServiceName)
+		assert.Equal(t, span.TraceID.String(), string(initMD["x-b3-traceid"]))
+		assert.Equal(t, span.ID.String(), string(initMD["x-b3-spanid"]))
+	})
+	t.Run("unary response", func(t *testing.T) {
+		rec := recorder.NewReporter()
+		z := newStreamTestPlugin(t, rec)
+		streamer := func(ctx context.Context, desc *client.ClientStreamDesc) (client.ClientStream, error) {
+			return &fakeStream{ctx: ctx, recv: [][]byte{[]byte("done")}}, nil
+		}
+		ctx, _ := newStreamTestContext()
+		cs, err := StreamClientFilter(z)(ctx, &client.ClientStreamDesc{ClientStreams: true}, streamer)
+		assert.Nil(t, err)
+		assert.Nil(t, cs.CloseSend())
+		var m []byte
+		assert.Nil(t, cs.RecvMsg(&m))
+		spans := rec.Flush()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "client", spans[0].Tags[TagStreamType])
+	})
+	t.Run("init error", func(t *testing.T) {
+		rec := recorder.NewReporter()
+		z := newStreamTestPlugin(t, rec)
+		streamer := func(ctx context.Context, desc *client.ClientStreamDesc) (client.ClientStream, error) {
+			return nil, errors.New("connect failed")
+		}
+		ctx, _ := newStreamTestContext()
+		_, err := StreamClientFilter(z)(ctx, &client.ClientStreamDesc{}, streamer)
+		assert.NotNil(t, err)
+		spans := rec.Flush()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "true", spans[0].Tags["error"])
+	})
+	t.Run("close send", func(t *testing.T) {
+		rec := recorder.NewReporter()
+		z := newStreamTestPlugin(t, rec)
+		streamer := func(ctx context.Context, desc *client.ClientStreamDesc) (client.ClientStream, error) {
+			return &fakeStream{ctx: ctx, recv: [][]byte{[]byte("a"), []byte("b")}}, nil
+		}
+		// the context is never canceled, and the caller stops reading
+		ctx, _ := newStreamTestContext()
+		cs, err := StreamClientFilter(z)(ctx, &client.ClientStreamDesc{ClientStreams: true, ServerStreams: true}, streamer)
+		assert.Nil(t, err)
+		cs.(*clientStream).linger = 50 * time.Millisecond
+		assert.Nil(t, cs.SendMsg([]byte("x")))
+		assert.Nil(t, cs.CloseSend())
+		var m []byte
+		assert.Nil(t, cs.RecvMsg(&m))
+		last := time.Now()
+		assert.Empty(t, rec.Flush())
+
+		var spans []model.SpanModel
+		assert.Eventually(t, func() bool {
+			spans = append(spans, rec.Flush()...)
+			return len(spans) == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "bidi", spans[0].Tags[TagStreamType])
+		assert.Equal(t, "1", spans[0].Tags[TagMessagesReceived])
+		// the span ends at the last call rather than when it is given up
+		assert.True(t, spans[0].Timestamp.Add(spans[0].Duration).Before(last.Add(25*time.Millisecond)))
+	})
+	t.Run("context canceled", func(t *testing.T) {
+		rec := recorder.NewReporter()
+		z := newStreamTestPlugin(t, rec)
+		ctx, _ := newStreamTestContext()
+		ctx, cancel := context.WithCancel(ctx)
+		streamer := func(ctx context.Context, desc *client.ClientStreamDesc) (client.ClientStream, error) {
+			return &fakeStream{ctx: ctx}, nil
+		}
+		_, err := StreamClientFilter(z)(ctx, &client.ClientStreamDesc{ServerStreams: true}, streamer)
+		assert.Nil(t, err)
+		cancel()
+		assert.Eventually(t, func() bool {
+			return len(rec.Flush()) == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func Test_streamType(t *testing.T) {
+	assert.Equal(t, "bidi", streamType(true, true))
+	assert.Equal(t, "client", streamType(true, false))
+	assert.Equal(t, "server", streamType(false, true))
+	assert.Equal(t, "unary", streamType(false, false))
+}
@@ -0,0 +1,167 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/opentracing/opentracing-go"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"google.golang.org/protobuf/proto"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+)
+
+// Tags set on server and client spans.
+const (
+	TagCallerService     = "trpc.caller_service"
+	TagCallerMethod      = "trpc.caller_method"
+	TagCalleeService     = "trpc.callee_service"
+	TagCalleeMethod      = "trpc.callee_method"
+	TagNamespace         = "trpc.namespace"
+	TagEnvName           = "trpc.env_name"
+	TagSetName           = "trpc.set_name"
+	TagSerializationType = "trpc.serialization_type"
+	TagCompressType      = "trpc.compress_type"
+	TagLocalAddr         = "trpc.local_addr"
+	TagRemoteAddr        = "trpc.remote_addr"
+	TagRequestSize       = "trpc.request_size"
+	TagResponseSize      = "trpc.response_size"
+	// TagRetCode is the trpc error code of the call, 0 on success.
+	TagRetCode = "trpc.ret_code"
+	// TagErrorType is the type of the trpc error: framework, callee_framework or business.
+	TagErrorType = "trpc.error_type"
+)
+
+var serializationTypes = map[int]string{
+	codec.SerializationTypePB:          "pb",
+	codec.SerializationTypeJSON:        "json",
+	codec.SerializationTypeFlatBuffer:  "flatbuffer",
+	codec.SerializationTypeNoop:        "noop",
+	codec.SerializationTypeXML:         "xml",
+	codec.SerializationTypeTextXML:     "textxml",
+	codec.SerializationTypeUnsupported: "unsupported",
+	codec.SerializationTypeForm:        "form",
+	codec.SerializationTypeGet:         "get",
+	codec.SerializationTypeFormData:    "formdata",
+}
+
+var compressTypes = map[int]string{
+	codec.CompressTypeNoop:         "noop",
+	codec.CompressTypeGzip:         "gzip",
+	codec.CompressTypeSnappy:       "snappy",
+	codec.CompressTypeZlib:         "zlib",
+	codec.CompressTypeStreamSnappy: "stream_snappy",
+	codec.CompressTypeBlockSnappy:  "block_snappy",
+}
+
+var errorTypes = map[int]string{
+	errs.ErrorTypeFramework:       "framework",
+	errs.ErrorTypeCalleeFramework: "callee_framework",
+	errs.ErrorTypeBusiness:        "business",
+}
+
+// setRPCTags sets the tags describing the call of msg on span, if span is reported
+// once finished with err. The set name of a client call is the one of the callee
+// if it is routed to a set.
+func setRPCTags(span opentracing.Span, msg codec.Msg, client bool, err error) {
+	if !reported(span, err) {
+		return
+	}
+	setStringTag(span, TagCallerService, msg.CallerServiceName())
+	setStringTag(span, TagCallerMethod, msg.CallerMethod())
+	setStringTag(span, TagCalleeService, msg.CalleeServiceName())
+	setStringTag(span, TagCalleeMethod, msg.CalleeMethod())
+	setStringTag(span, TagNamespace, msg.Namespace())
+	setStringTag(span, TagEnvName, msg.EnvName())
+	setName := msg.SetName()
+	if client && msg.CalleeSetName() != "" {
+		setName = msg.CalleeSetName()
+	}
+	setStringTag(span, TagSetName, setName)
+	span.SetTag(TagSerializationType, typeName(serializationTypes, msg.SerializationType()))
+	span.SetTag(TagCompressType, typeName(compressTypes, msg.CompressType()))
+	if addr := msg.LocalAddr(); addr != nil {
+		span.SetTag(TagLocalAddr, addr.String())
+	}
+	if addr := msg.RemoteAddr(); addr != nil {
+		span.SetTag(TagRemoteAddr, addr.String())
+	}
+}
+
+// setResultTags sets the body sizes and the trpc error code of the call on span.
+// The sizes are only measured for spans which are reported.
+func setResultTags(span opentracing.Span, req, rsp interface{}, err error) {
+	if reported(span, err) {
+		if size, ok := bodySize(req); ok {
+			span.SetTag(TagRequestSize, size)
+		}
+		if size, ok := bodySize(rsp); ok && err == nil {
+			span.SetTag(TagResponseSize, size)
+		}
+	}
+	span.SetTag(TagRetCode, int(errs.Code(err)))
+	var e *errs.Error
+	if errors.As(err, &e) && e != nil {
+		span.SetTag(TagErrorType, typeName(errorTypes, e.Type))
+	}
+}
+
+// reported tells whether span will be reported once finished with err, so
+// that tags costly to compute are skipped on the unsampled majority of calls.
+func reported(span opentracing.Span, err error) bool {
+	if b, ok := span.(*baggageSpan); ok {
+		span = b.Span
+	}
+	if _, ok := span.(*errorSampledSpan); ok {
+		return err != nil
+	}
+	sc, ok := span.Context().(zipkinOpentracing.SpanContext)
+	return !ok || sc.Debug || (sc.Sampled != nil && *sc.Sampled)
+}
+
+// bodySize returns the encoded size of a request or response body. Only
+// protobuf messages and raw bytes are measured, since encoding other bodies
+// again just for a tag costs too much.
+func bodySize(body interface{}) (int, bool) {
+	switch b := body.(type) {
+	case proto.Message:
+		return proto.Size(b), true
+	case []byte:
+		return len(b), true
+	case *[]byte:
+		if b != nil {
+			return len(*b), true
+		}
+	case *codec.Body:
+		if b != nil {
+			return len(b.Data), true
+		}
+	}
+	return 0, false
+}
+
+func typeName(names map[int]string, t int) string {
+	if name, ok := names[t]; ok {
+		return name
+	}
+	return strconv.Itoa(t)
+}
+
+func setStringTag(span opentracing.Span, key, val string) {
+	if val != "" {
+		span.SetTag(key, val)
+	}
+}
@@ -0,0 +1,163 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+)
+
+func Test_bodySize(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   interface{}
+		want   int
+		wantOK bool
+	}{
+		{"proto", wrapperspb.String("hello"), 7, true},
+		{"bytes", []byte("hello"), 5, true},
+		{"bytes pointer", &[]byte{1, 2}, 2, true},
+		{"codec body", &codec.Body{Data: []byte("hi")}, 2, true},
+		{"other", struct{}{}, 0, false},
+		{"nil", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, ok := bodySize(tt.body)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, size)
+		})
+	}
+}
+
+func TestFilter_RPCTags(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	z := &zipkinPlugin{tracers: map[string]opentracing.Tracer{"trpc.app.server.Service": tracer}}
+
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCallerServiceName("trpc.app.caller.Service")
+	msg.WithCallerMethod("Call")
+	msg.WithCalleeServiceName("trpc.app.server.Service")
+	msg.WithCalleeMethod("Hello")
+	msg.WithNamespace("Production")
+	msg.WithEnvName("formal")
+	msg.WithSetName("set.sz.1")
+	msg.WithSerializationType(codec.SerializationTypeJSON)
+	msg.WithCompressType(codec.CompressTypeGzip)
+	msg.WithLocalAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8000})
+	msg.WithRemoteAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 9000})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+		clientMsg.WithCallerServiceName("trpc.app.server.Service")
+		clientMsg.WithCalleeServiceName("trpc.app.backend.Service")
+		clientMsg.WithCalleeSetName("set.sz.2")
+		err := ClientFilter(z)(clientCtx, []byte("req"), nil, func(ctx context.Context, req, rsp interface{}) error {
+			return &errs.Error{Type: errs.ErrorTypeCalleeFramework, Code: errs.RetClientTimeout, Msg: "timeout"}
+		})
+		assert.NotNil(t, err)
+		return wrapperspb.String("hello"), nil
+	}
+	_, err = ServerFilter(z)(ctx, []byte("request"), handler)
+	assert.Nil(t, err)
+
+	spans := rec.Flush()
+	assert.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, map[string]string{
+		TagCallerService:     "trpc.app.caller.Service",
+		TagCallerMethod:      "Call",
+		TagCalleeService:     "trpc.app.server.Service",
+		TagCalleeMethod:      "Hello",
+		TagNamespace:         "Production",
+		TagEnvName:           "formal",
+		TagSetName:           "set.sz.1",
+		TagSerializationType: "json",
+		TagCompressType:      "gzip",
+		TagLocalAddr:         "10.0.0.1:8000",
+		TagRemoteAddr:        "10.0.0.2:9000",
+		TagRequestSize:       "7",
+		TagResponseSize:      "7",
+		TagRetCode:           "0",
+	}, server.Tags)
+	assert.Equal(t, "trpc.app.backend.Service", client.Tags[TagCalleeService])
+	assert.Equal(t, "set.sz.2", client.Tags[TagSetName])
+	assert.Equal(t, "3", client.Tags[TagRequestSize])
+	assert.Equal(t, fmt.Sprint(int(errs.RetClientTimeout)), client.Tags[TagRetCode])
+	assert.Equal(t, "callee_framework", client.Tags[TagErrorType])
+	assert.Equal(t, "true", client.Tags["error"])
+}
+
+func Test_setRPCTags(t *testing.T) {
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: NeverSampler}}
+	tracer, err := c.newOpenTracingTracer(recorder.NewReporter())
+	assert.Nil(t, err)
+	z := &zipkinPlugin{errorSampling: true}
+	_, msg := codec.WithNewMessage(context.Background())
+	msg.WithCallerServiceName("trpc.app.caller.Service")
+
+	// the tags of spans which are not reported are skipped
+	span := z.sampleErrors(tracer, tracer.StartSpan("/a"), "/a").(*errorSampledSpan)
+	setRPCTags(span, msg, false, nil)
+	assert.NotContains(t, span.tags, TagCallerService)
+
+	span = z.sampleErrors(tracer, tracer.StartSpan("/a"), "/a").(*errorSampledSpan)
+	setRPCTags(span, msg, false, errors.New("failed"))
+	assert.Equal(t, "trpc.app.caller.Service", span.tags[TagCallerService])
+}
+
+func Test_reported(t *testing.T) {
+	newTracer := func(sampler string) opentracing.Tracer {
+		c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: sampler}}
+		tracer, err := c.newOpenTracingTracer(recorder.NewReporter())
+		assert.Nil(t, err)
+		return tracer
+	}
+	sampled := newTracer(AlwaysSampler).StartSpan("/a")
+	unsampled := newTracer(NeverSampler).StartSpan("/a")
+	z := &zipkinPlugin{errorSampling: true}
+	errorSampled := z.sampleErrors(newTracer(NeverSampler), newTracer(NeverSampler).StartSpan("/a"), "/a")
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		span opentracing.Span
+		err  error
+		want bool
+	}{
+		{"sampled", sampled, nil, true},
+		{"unsampled", unsampled, failed, false},
+		{"baggage span", &baggageSpan{Span: sampled}, nil, true},
+		{"error sampled success", errorSampled, nil, false},
+		{"error sampled failure", &baggageSpan{Span: errorSampled}, failed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, reported(tt.span, tt.err))
+		})
+	}
+}
@@ -0,0 +1,232 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+const (
+	defaultTailSamplingWindow   = 5 * time.Second
+	defaultTailSamplingMaxSpans = 100000
+	// maxTailSamplingSweep bounds the delay of reporting traces past their window.
+	maxTailSamplingSweep = time.Second
+)
+
+// tailSamplingReporter buffers the spans of each trace, and forwards all of them
+// to the underlying reporter if any span is interesting, otherwise only traces
+// sampled at the base rate. A trace is decided when its local root span is
+// finished, when its window expires, or when the buffer is full. Decisions are
+// kept for another window, and apply to the spans of the trace finishing late.
+type tailSamplingReporter struct {
+	reporter reporter.Reporter
+	window   time.Duration
+	latency  time.Duration // disabled if 0
+	tags     map[string]string
+	base     zipkin.Sampler
+	maxSpans int
+
+	mu       sync.Mutex
+	traces   map[model.TraceID]*tailTrace
+	order    *list.List // of *tailTrace, by deadline
+	numSpans int
+	// decided traces without spans, by deadline
+	decided   map[model.TraceID]*tailTrace
+	decisions *list.List
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// tailTrace holds the buffered spans of a trace.
+type tailTrace struct {
+	id       model.TraceID
+	spans    []model.SpanModel
+	keep     bool
+	deadline time.Time
+	elem     *list.Element
+}
+
+func newTailSamplingReporter(rep reporter.Reporter, c *TailSamplingConfig) *tailSamplingReporter {
+	r := &tailSamplingReporter{
+		reporter:  rep,
+		window:    defaultTailSamplingWindow,
+		latency:   time.Duration(c.LatencyThresholdMilliseconds) * time.Millisecond,
+		tags:      c.Tags,
+		base:      probabilisticSampler(c.BaseRate),
+		maxSpans:  defaultTailSamplingMaxSpans,
+		traces:    make(map[model.TraceID]*tailTrace),
+		order:     list.New(),
+		decided:   make(map[model.TraceID]*tailTrace),
+		decisions: list.New(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if c.WindowSeconds > 0 {
+		r.window = time.Duration(c.WindowSeconds) * time.Second
+	}
+	if c.MaxSpans > 0 {
+		r.maxSpans = c.MaxSpans
+	}
+	go r.run()
+	return r
+}
+
+// Send implements reporter.Reporter.
+func (r *tailSamplingReporter) Send(s model.SpanModel) {
+	r.mu.Lock()
+	if d, ok := r.decided[s.TraceID]; ok {
+		if d.keep {
+			r.mu.Unlock()
+			r.reporter.Send(s)
+			return
+		}
+		if !r.interesting(&s) {
+			r.mu.Unlock()
+			return
+		}
+		// a dropped trace turning interesting later is buffered again
+		r.forgetLocked(d)
+	}
+	t, ok := r.traces[s.TraceID]
+	if !ok {
+		t = &tailTrace{id: s.TraceID, deadline: time.Now().Add(r.window)}
+		t.elem = r.order.PushBack(t)
+		r.traces[s.TraceID] = t
+	}
+	t.spans = append(t.spans, s)
+	r.numSpans++
+	if !t.keep && r.interesting(&s) {
+		t.keep = true
+	}
+
+	var spans []model.SpanModel
+	if localRoot(&s) {
+		spans = r.decideLocked(t)
+	}
+	for r.numSpans > r.maxSpans {
+		spans = append(spans, r.decideLocked(r.order.Front().Value.(*tailTrace))...)
+	}
+	r.mu.Unlock()
+	r.forward(spans)
+}
+
+// Close decides all buffered traces and closes the underlying reporter.
+func (r *tailSamplingReporter) Close() error {
+	close(r.stop)
+	<-r.done
+	r.mu.Lock()
+	var spans []model.SpanModel
+	for r.order.Len() > 0 {
+		spans = append(spans, r.decideLocked(r.order.Front().Value.(*tailTrace))...)
+	}
+	r.mu.Unlock()
+	r.forward(spans)
+	return r.reporter.Close()
+}
+
+func (r *tailSamplingReporter) run() {
+	defer close(r.done)
+	interval := r.window
+	if interval > maxTailSamplingSweep {
+		interval = maxTailSamplingSweep
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			r.sweep(now)
+		}
+	}
+}
+
+// sweep decides the traces whose window has expired by now, and forgets the
+// decisions which have expired.
+func (r *tailSamplingReporter) sweep(now time.Time) {
+	r.mu.Lock()
+	var spans []model.SpanModel
+	for e := r.order.Front(); e != nil && !e.Value.(*tailTrace).deadline.After(now); e = r.order.Front() {
+		spans = append(spans, r.decideLocked(e.Value.(*tailTrace))...)
+	}
+	for e := r.decisions.Front(); e != nil && !e.Value.(*tailTrace).deadline.After(now); e = r.decisions.Front() {
+		r.forgetLocked(e.Value.(*tailTrace))
+	}
+	r.mu.Unlock()
+	r.forward(spans)
+}
+
+// decideLocked removes t from the buffer, remembers the decision, and returns
+// its spans to forward.
+func (r *tailSamplingReporter) decideLocked(t *tailTrace) []model.SpanModel {
+	delete(r.traces, t.id)
+	r.order.Remove(t.elem)
+	r.numSpans -= len(t.spans)
+	keep := t.keep || r.base(t.id.Low)
+	d := &tailTrace{id: t.id, keep: keep, deadline: time.Now().Add(r.window)}
+	d.elem = r.decisions.PushBack(d)
+	r.decided[t.id] = d
+	for r.decisions.Len() > r.maxSpans {
+		r.forgetLocked(r.decisions.Front().Value.(*tailTrace))
+	}
+	if keep {
+		return t.spans
+	}
+	return nil
+}
+
+// forgetLocked removes the decision d.
+func (r *tailSamplingReporter) forgetLocked(d *tailTrace) {
+	delete(r.decided, d.id)
+	r.decisions.Remove(d.elem)
+}
+
+func (r *tailSamplingReporter) forward(spans []model.SpanModel) {
+	for _, s := range spans {
+		r.reporter.Send(s)
+	}
+}
+
+// interesting tells whether s makes its whole trace forwarded.
+func (r *tailSamplingReporter) interesting(s *model.SpanModel) bool {
+	if s.Debug {
+		return true
+	}
+	if _, ok := s.Tags["error"]; ok {
+		return true
+	}
+	if r.latency > 0 && s.Duration >= r.latency {
+		return true
+	}
+	for k, want := range r.tags {
+		if v, ok := s.Tags[k]; ok && (want == "" || v == want) {
+			return true
+		}
+	}
+	return false
+}
+
+// localRoot tells whether s is the root of its trace in this process, which
+// normally finishes after all other local spans of the trace.
+func localRoot(s *model.SpanModel) bool {
+	return s.ParentID == nil || s.Kind == model.Server || s.Kind == model.Consumer
+}
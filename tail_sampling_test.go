@@ -0,0 +1,211 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+// keptReporter keeps the recorded spans on Close.
+type keptReporter struct {
+	*recorder.ReporterRecorder
+}
+
+func (keptReporter) Close() error { return nil }
+
+func newTailTestSpans(traceID uint64, childTags map[string]string, childDuration time.Duration) []model.SpanModel {
+	parent := model.ID(1)
+	tid := model.TraceID{Low: traceID}
+	return []model.SpanModel{
+		{
+			SpanContext: model.SpanContext{TraceID: tid, ID: 2, ParentID: &parent},
+			Kind:        model.Client,
+			Duration:    childDuration,
+			Tags:        childTags,
+		},
+		{
+			SpanContext: model.SpanContext{TraceID: tid, ID: parent},
+			Kind:        model.Server,
+			Duration:    time.Millisecond,
+		},
+	}
+}
+
+func Test_tailSamplingReporter(t *testing.T) {
+	const low = 1
+	tests := []struct {
+		name          string
+		cfg           *TailSamplingConfig
+		childTags     map[string]string
+		childDuration time.Duration
+		traceID       uint64
+		want          int
+	}{
+		{"dropped", &TailSamplingConfig{}, nil, 0, low, 0},
+		{"error", &TailSamplingConfig{}, map[string]string{"error": "true"}, 0, low, 2},
+		{"slow", &TailSamplingConfig{LatencyThresholdMilliseconds: 100}, nil, time.Second, low, 2},
+		{"fast", &TailSamplingConfig{LatencyThresholdMilliseconds: 100}, nil, time.Millisecond, low, 0},
+		{"tag", &TailSamplingConfig{Tags: map[string]string{"trpc.ret_code": "21"}},
+			map[string]string{"trpc.ret_code": "21"}, 0, low, 2},
+		{"tag mismatch", &TailSamplingConfig{Tags: map[string]string{"trpc.ret_code": "21"}},
+			map[string]string{"trpc.ret_code": "0"}, 0, low, 0},
+		{"any tag value", &TailSamplingConfig{Tags: map[string]string{"user.vip": ""}},
+			map[string]string{"user.vip": "gold"}, 0, low, 2},
+		{"base rate", &TailSamplingConfig{BaseRate: 0.5}, nil, 0, low, 2},
+		{"base rate miss", &TailSamplingConfig{BaseRate: 0.5}, nil, 0, ^uint64(0) - 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recorder.NewReporter()
+			r := newTailSamplingReporter(rec, tt.cfg)
+			defer r.Close()
+			for _, s := range newTailTestSpans(tt.traceID, tt.childTags, tt.childDuration) {
+				r.Send(s)
+			}
+			// the trace is decided once its local root is finished
+			assert.Len(t, rec.Flush(), tt.want)
+			assert.Empty(t, r.traces)
+			assert.Equal(t, 0, r.numSpans)
+		})
+	}
+}
+
+func Test_tailSamplingReporter_bounds(t *testing.T) {
+	rec := recorder.NewReporter()
+	r := newTailSamplingReporter(keptReporter{rec}, &TailSamplingConfig{WindowSeconds: 60, MaxSpans: 2})
+	errSpan := func(traceID uint64) model.SpanModel {
+		return newTailTestSpans(traceID, map[string]string{"error": "true"}, 0)[0]
+	}
+
+	// traces without their local root wait for the window
+	r.Send(errSpan(1))
+	assert.Empty(t, rec.Flush())
+	r.sweep(time.Now())
+	assert.Empty(t, rec.Flush())
+	r.sweep(time.Now().Add(time.Minute))
+	assert.Len(t, rec.Flush(), 1)
+
+	// the oldest trace is decided once the buffer is full
+	r.Send(errSpan(2))
+	r.Send(errSpan(3))
+	assert.Empty(t, rec.Flush())
+	r.Send(errSpan(4))
+	spans := rec.Flush()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, uint64(2), spans[0].TraceID.Low)
+
+	// buffered traces are decided on close
+	assert.Nil(t, r.Close())
+	assert.Len(t, rec.Flush(), 2)
+}
+
+func Test_tailSamplingReporter_lateSpans(t *testing.T) {
+	rec := recorder.NewReporter()
+	r := newTailSamplingReporter(keptReporter{rec}, &TailSamplingConfig{WindowSeconds: 60})
+	defer r.Close()
+	late := func(traceID uint64, tags map[string]string) model.SpanModel {
+		parent := model.ID(1)
+		return model.SpanModel{
+			SpanContext: model.SpanContext{TraceID: model.TraceID{Low: traceID}, ID: 3, ParentID: &parent},
+			Kind:        model.Client,
+			Tags:        tags,
+		}
+	}
+
+	// spans finishing after their trace is kept are forwarded at once
+	for _, s := range newTailTestSpans(1, map[string]string{"error": "true"}, 0) {
+		r.Send(s)
+	}
+	assert.Len(t, rec.Flush(), 2)
+	r.Send(late(1, nil))
+	assert.Len(t, rec.Flush(), 1)
+
+	// those of dropped traces are dropped, unless they are interesting
+	for _, s := range newTailTestSpans(2, nil, 0) {
+		r.Send(s)
+	}
+	r.Send(late(2, nil))
+	assert.Empty(t, rec.Flush())
+	assert.Empty(t, r.traces)
+	r.Send(late(2, map[string]string{"error": "true"}))
+	assert.Len(t, r.traces, 1)
+
+	// decisions are forgotten after another window
+	r.sweep(time.Now().Add(2 * time.Minute))
+	assert.Len(t, rec.Flush(), 1)
+	assert.Empty(t, r.decided)
+	assert.Equal(t, 0, r.decisions.Len())
+}
+
+func TestServerFilter_TailSampling(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tail := newTailSamplingReporter(keptReporter{rec}, &TailSamplingConfig{})
+	defer tail.Close()
+	tracer, err := c.newOpenTracingTracer(tail)
+	assert.Nil(t, err)
+	z := &zipkinPlugin{tracers: map[string]opentracing.Tracer{"trpc.app.server.Service": tracer}}
+
+	for _, handlerErr := range []error{nil, errors.New("failed")} {
+		ctx, msg := codec.WithNewMessage(context.Background())
+		msg.WithCalleeServiceName("trpc.app.server.Service")
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+			clientMsg.WithCallerServiceName("trpc.app.server.Service")
+			_ = ClientFilter(z)(clientCtx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+				return nil
+			})
+			return nil, handlerErr
+		}
+		_, _ = ServerFilter(z)(ctx, nil, handler)
+	}
+	// only the failed request is reported, with its client span
+	spans := rec.Flush()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, spans[0].TraceID, spans[1].TraceID)
+	assert.Equal(t, "true", spans[1].Tags["error"])
+}
+
+func TestConfig_ValidateTailSampling(t *testing.T) {
+	err := (&Config{
+		Sampler: &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: NoopReporter, TailSampling: &TailSamplingConfig{
+			WindowSeconds:                -1,
+			MaxSpans:                     -1,
+			LatencyThresholdMilliseconds: -1,
+			BaseRate:                     1.5,
+		}},
+	}).Validate()
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	var fields []string
+	for _, fe := range errs {
+		fields = append(fields, fe.Field)
+	}
+	assert.Equal(t, []string{
+		"reporter.tail_sampling.window_seconds",
+		"reporter.tail_sampling.max_spans",
+		"reporter.tail_sampling.latency_threshold_ms",
+		"reporter.tail_sampling.base_rate",
+	}, fields)
+}
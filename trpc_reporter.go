@@ -0,0 +1,113 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"context"
+	stdHTTP "net/http"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/reporter"
+	"github.com/openzipkin/zipkin-go/reporter/http"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	trpcHTTP "trpc.group/trpc-go/trpc-go/http"
+)
+
+const (
+	defaultTRPCReporterName = "trpc.zipkin.collector"
+	defaultTRPCReporterPath = "/api/v2/spans"
+)
+
+// newTRPCReporter news a zipkin-go http reporter sending spans through a trpc-go
+// http client. opts are appended to the client options.
+func newTRPCReporter(c *TRPCReporterConfig, opts ...client.Option) (reporter.Reporter, error) {
+	serializer, err := newSpanSerializer(c.Encoding)
+	if err != nil {
+		return nil, invalidConfigErr("reporter.trpc.encoding")
+	}
+	name := c.Name
+	if name == "" {
+		name = defaultTRPCReporterName
+	}
+	path := c.Path
+	if path == "" {
+		path = defaultTRPCReporterPath
+	}
+	var clientOpts []client.Option
+	if c.Target != "" {
+		clientOpts = append(clientOpts, client.WithTarget(c.Target))
+	}
+	if c.TimeoutSeconds > 0 {
+		clientOpts = append(clientOpts, client.WithTimeout(time.Duration(c.TimeoutSeconds)*time.Second))
+	}
+	proxy := trpcHTTP.NewClientProxy(name, append(clientOpts, opts...)...)
+
+	reporterOpts := []http.ReporterOption{
+		http.Client(&stdHTTP.Client{Transport: trpcTransport{proxy}}),
+		http.Serializer(serializer),
+	}
+	if c.BatchIntervalSeconds > 0 {
+		reporterOpts = append(reporterOpts, http.BatchInterval(time.Duration(c.BatchIntervalSeconds)*time.Second))
+	}
+	if c.BatchSize > 0 {
+		reporterOpts = append(reporterOpts, http.BatchSize(c.BatchSize))
+	}
+	if c.MaxBacklog > 0 {
+		reporterOpts = append(reporterOpts, http.MaxBacklog(c.MaxBacklog))
+	}
+	// the host is resolved by the trpc client
+	return http.NewReporter("http://"+name+path, reporterOpts...), nil
+}
+
+// trpcTransport sends the requests of the reporter through a trpc http client,
+// which addresses the collector by its target rather than by the request url.
+// The requests are not traced, so that reporting spans does not create spans.
+type trpcTransport struct {
+	proxy trpcHTTP.Client
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t trpcTransport) RoundTrip(req *stdHTTP.Request) (*stdHTTP.Response, error) {
+	rspHead := &trpcHTTP.ClientRspHeader{}
+	err := t.proxy.Post(withoutTracing(req.Context()), req.URL.Path, nil, nil,
+		client.WithReqHead(&trpcHTTP.ClientReqHeader{Method: req.Method, Header: req.Header, ReqBody: req.Body}),
+		client.WithRspHead(rspHead),
+		client.WithCurrentCompressType(codec.CompressTypeNoop),
+		// the body is sent as is, like the RoundTrip of the trpc std http client
+		client.WithCurrentSerializationType(-1),
+	)
+	if err != nil {
+		// the reporter checks the status code itself
+		if rspHead.Response != nil && rspHead.Response.StatusCode == int(errs.Code(err)) {
+			return rspHead.Response, nil
+		}
+		return nil, err
+	}
+	return rspHead.Response, nil
+}
+
+type untracedKey struct{}
+
+// withoutTracing returns a copy of ctx whose client calls are not traced.
+func withoutTracing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untracedKey{}, true)
+}
+
+// untraced tells whether the client calls made with ctx are not traced.
+func untraced(ctx context.Context) bool {
+	v, _ := ctx.Value(untracedKey{}).(bool)
+	return v
+}
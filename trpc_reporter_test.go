@@ -0,0 +1,88 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 THL A29 Limited, a Tencent company.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package zipkin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+func TestTRPCReporter(t *testing.T) {
+	type request struct {
+		path        string
+		contentType string
+		spans       []*model.SpanModel
+	}
+	received := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		contentType := r.Header.Get("Content-Type")
+		received <- request{path: r.URL.Path, contentType: contentType, spans: decodeSpans(t, Proto3Encoding, b)}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	// the calls of the reporter, without caller, pass through the tracing filter untraced
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	z := &zipkinPlugin{tracers: map[string]opentracing.Tracer{"": tracer}}
+
+	rep, err := newTRPCReporter(&TRPCReporterConfig{
+		Target:   "ip://" + strings.TrimPrefix(srv.URL, "http://"),
+		Path:     "/collector/spans",
+		Encoding: Proto3Encoding,
+	}, client.WithFilter(ClientFilter(z)))
+	assert.Nil(t, err)
+	want := newEncodingTestSpan()
+	rep.Send(want)
+	assert.Nil(t, rep.Close())
+
+	select {
+	case req := <-received:
+		assert.Equal(t, "/collector/spans", req.path)
+		assert.Equal(t, "application/x-protobuf", req.contentType)
+		assert.Len(t, req.spans, 1)
+		assert.Equal(t, want, *req.spans[0])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans received")
+	}
+	assert.Empty(t, rec.Flush())
+}
+
+func TestConfig_ValidateTRPCReporter(t *testing.T) {
+	err := (&Config{
+		Sampler: &SamplerConfig{Type: AlwaysSampler},
+		Reporter: &ReporterConfig{Type: TRPCReporter, TRPC: &TRPCReporterConfig{
+			Path:     "api/v2/spans",
+			Encoding: "thrift",
+		}},
+	}).Validate()
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		"param [reporter.trpc.target] invalid: target or name should be set; "+
+		"param [reporter.trpc.path] invalid: should start with /; "+
+		`param [reporter.trpc.encoding] invalid: unknown encoding "thrift"`)
+}
@@ -16,18 +16,24 @@ package zipkin
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	traceLog "github.com/opentracing/opentracing-go/log"
-	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	zipkinOpentracing "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go/model"
 	trpc "trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/client"
 	"trpc.group/trpc-go/trpc-go/codec"
 	"trpc.group/trpc-go/trpc-go/filter"
 	trpcHTTP "trpc.group/trpc-go/trpc-go/http"
 	"trpc.group/trpc-go/trpc-go/log"
 	"trpc.group/trpc-go/trpc-go/plugin"
+	"trpc.group/trpc-go/trpc-go/server"
 )
 
 const (
@@ -43,6 +49,35 @@ func init() {
 type zipkinPlugin struct {
 	// each service has a tracer
 	tracers map[string]opentracing.Tracer
+	// reporters holds all reporters created by Setup, the first one is shared by
+	// the global tracer and all service tracers without their own reporter config
+	reporters []*sharedReporter
+	// shutdownTimeout bounds the time to flush the reporters on Close
+	shutdownTimeout time.Duration
+	// propagators injects and extracts trace context across calls
+	propagators propagators
+	// baggage restricts the baggage carried across calls, nil if baggage is not enabled
+	baggage *baggagePolicy
+	// forceSampler forces the sampling of requests, nil if it is not enabled
+	forceSampler *forceSampler
+	// errorSampling reports failed calls of unsampled traces
+	errorSampling bool
+	// parentPolicies holds the parent policies keyed by service name, the
+	// policy of the global tracer has the empty name
+	parentPolicies map[string]*parentPolicy
+	// incomingContexts holds how the incoming trace context is used keyed by
+	// service name, in the same way as parentPolicies
+	incomingContexts map[string]string
+
+	// name is the name of the plugin config
+	name string
+	// mu guards reporters, shutdownTimeout and the fields below against reloads
+	mu sync.Mutex
+	// cfg is the config in effect, only kept if watch is enabled
+	cfg *Config
+	// reloadables holds the tracers built with watch enabled, keyed by service
+	// name, the global tracer has the empty name
+	reloadables map[string]*reloadableTracer
 }
 
 // Name of plugin
@@ -66,36 +101,145 @@ func (z *zipkinPlugin) Setup(name string, decoder plugin.Decoder) error {
 
 	// set global configs
 	cfg.withDefault()
-	tracer, err := cfg.NewOpenTracingTracer()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	z.shutdownTimeout = cfg.shutdownTimeout()
+	if z.propagators, err = newPropagators(cfg.Propagation, cfg.B3InjectStyle); err != nil {
+		return err
+	}
+	if cfg.Baggage != nil {
+		z.baggage = newBaggagePolicy(cfg.Baggage)
+		z.propagators = append(z.propagators, baggagePropagator{})
+	}
+	if cfg.ForceSample != nil {
+		z.forceSampler = newForceSampler(cfg.ForceSample)
+	}
+	z.errorSampling = cfg.ErrorSampling
+	z.parentPolicies = map[string]*parentPolicy{"": newParentPolicy(cfg.ParentPolicy)}
+	z.incomingContexts = map[string]string{"": cfg.TrustIncomingContext}
+	rep, err := z.newReporter(&cfg)
 	if err != nil {
-		log.Fatalf("unable to create zipkin tracer: %+v\n", err)
+		return err
+	}
+	tracer, err := z.newTracer(&cfg, rep, "")
+	if err != nil {
+		z.closeReporters()
+		return fmt.Errorf("trpc-opentracing-zipkin: create tracer failed: %w", err)
+	}
+	if err := z.setupServiceTracers(&cfg, rep); err != nil {
+		z.closeReporters()
 		return err
 	}
 
 	// optionally set as Global OpenTracing tracer instance
 	opentracing.SetGlobalTracer(tracer)
 
-	// create a tracer for each service
+	filter.Register(name, ServerFilter(z), ClientFilter(z))
+	server.RegisterStreamFilter(name, StreamServerFilter(z))
+	client.RegisterStreamFilter(name, StreamClientFilter(z))
+
+	if cfg.Watch {
+		z.name, z.cfg = name, &cfg
+		if err := z.watch(trpc.ServerConfigPath); err != nil {
+			log.Warnf("trpc-opentracing-zipkin: watch config %s failed, config is not reloaded: %v",
+				trpc.ServerConfigPath, err)
+		}
+	}
+	return nil
+}
+
+// setupServiceTracers creates a tracer for each service. Services without
+// their own reporter config share the reporter of the global tracer.
+func (z *zipkinPlugin) setupServiceTracers(cfg *Config, rep *sharedReporter) error {
+	services := make(map[string]bool, len(trpc.GlobalConfig().Server.Service))
 	for _, s := range trpc.GlobalConfig().Server.Service {
+		services[s.Name] = true
+		serviceCfg, enabled := cfg.serviceConfig(s.Name)
+		if !enabled {
+			z.tracers[s.Name] = opentracing.NoopTracer{}
+			continue
+		}
+		z.parentPolicies[s.Name] = newParentPolicy(serviceCfg.ParentPolicy)
+		z.incomingContexts[s.Name] = serviceCfg.TrustIncomingContext
 		// If there is name and ip in service, then report to it
-		cfg.withServiceName(s.Name)
-		cfg.withHostPort(s.IP, s.Port)
-
-		tracer, err = cfg.NewOpenTracingTracer()
+		serviceCfg.withServiceName(s.Name)
+		serviceCfg.withHostPort(s.IP, s.Port)
+
+		serviceRep := rep
+		if serviceCfg.Reporter != cfg.Reporter {
+			var err error
+			if serviceRep, err = z.newReporter(&serviceCfg); err != nil {
+				return err
+			}
+		}
+		tracer, err := z.newTracer(&serviceCfg, serviceRep, s.Name)
 		if err != nil {
-			log.Fatalf("unable to create tracer: %+v\n", err)
-			return err
+			return fmt.Errorf("trpc-opentracing-zipkin: create tracer for service %s failed: %w", s.Name, err)
 		}
-
 		z.tracers[s.Name] = tracer
 	}
-
-	filter.Register(name, ServerFilter(z), ClientFilter(z))
+	for name := range cfg.Services {
+		if !services[name] {
+			log.Warnf("trpc-opentracing-zipkin: service %s configured under services is not found in server config", name)
+		}
+	}
 	return nil
 }
 
+// newTracer news the tracer of service reporting through rep, whose sampler
+// and reporter are swapped on reload if watch is enabled.
+func (z *zipkinPlugin) newTracer(cfg *Config, rep *sharedReporter, service string) (opentracing.Tracer, error) {
+	if !cfg.Watch {
+		return cfg.newOpenTracingTracer(rep)
+	}
+	tracer, t, err := cfg.newReloadableTracer(rep)
+	if err != nil {
+		return nil, err
+	}
+	if z.reloadables == nil {
+		z.reloadables = make(map[string]*reloadableTracer)
+	}
+	z.reloadables[service] = t
+	return tracer, nil
+}
+
+// newReporter news the reporter of cfg and keeps it to be closed on Close.
+func (z *zipkinPlugin) newReporter(cfg *Config) (*sharedReporter, error) {
+	rep, err := cfg.newReporter()
+	if err != nil {
+		return nil, fmt.Errorf("trpc-opentracing-zipkin: create reporter failed: %w", err)
+	}
+	sr := newSharedReporter(rep)
+	z.reporters = append(z.reporters, sr)
+	return sr, nil
+}
+
+func (z *zipkinPlugin) closeReporters() {
+	for _, r := range z.reporters {
+		_ = r.Close()
+	}
+}
+
+// Close flushes and closes the reporters, it is called by trpc on server shutdown.
+func (z *zipkinPlugin) Close() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if len(z.reporters) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), z.shutdownTimeout)
+	defer cancel()
+	return shutdownReporters(ctx, z.reporters...)
+}
+
 type metadataTextMap codec.MetaData
 
+// Get implements textMap
+func (m metadataTextMap) Get(key string) string {
+	return string(m[key])
+}
+
 // Set implements opentracing.TextMapWriter
 func (m metadataTextMap) Set(key, val string) {
 	m[key] = []byte(val)
@@ -122,37 +266,45 @@ func ServerFilter(z *zipkinPlugin) filter.ServerFilter {
 			tracer = opentracing.GlobalTracer()
 		}
 
-		var parentSpanContext opentracing.SpanContext
+		var carrier textMap
 		ctxValueHeader := ctx.Value(trpcHTTP.ContextKeyHeader)
 		httpHeader, ok := ctxValueHeader.(*trpcHTTP.Header)
 		if ok {
 			// for http protocol
 			log.Debugf("headers: %+v", httpHeader.Request.Header)
-			headerCarrier := opentracing.HTTPHeadersCarrier(httpHeader.Request.Header)
-			parentSpanContext, err = tracer.Extract(opentracing.HTTPHeaders, headerCarrier)
+			carrier = httpHeaderTextMap(httpHeader.Request.Header)
 		} else {
 			// for trpc protocol
 			md := msg.ServerMetaData()
 			log.Debugf("metadata: %+v ", md)
-			textMapCarrier := metadataTextMap(md)
-			parentSpanContext, err = tracer.Extract(opentracing.HTTPHeaders, textMapCarrier)
+			carrier = metadataTextMap(md)
 		}
 
-		if err != nil && err != opentracing.ErrSpanContextNotFound {
-			log.Errorf("trpc-opentracing-zipkin: failed to parse trace information: %v", err)
+		tc, found := z.extract(carrier, msg)
+		var parentSpanContext opentracing.SpanContext
+		if found {
+			parentSpanContext = zipkinOpentracing.SpanContext(tc.spanContext)
 		}
-		serverSpan := tracer.StartSpan(
-			msg.ServerRPCName(),
+		op := serverOperation(msg)
+		var serverSpan opentracing.Span = startSpan(tracer, op,
 			ext.RPCServerOption(parentSpanContext),
 		)
+		serverSpan = z.sampleErrors(tracer, serverSpan, op.name, ext.SpanKindRPCServer)
+		tagExternal(serverSpan, tc)
+		z.baggage.restrict(tc)
+		z.baggage.tag(serverSpan, tc)
+		serverSpan = &baggageSpan{Span: serverSpan, tc: tc}
 
 		ctx = opentracing.ContextWithSpan(ctx, serverSpan)
+		ctx = contextWithTraceContext(ctx, tc)
 
 		rsp, err = handler(ctx, req)
 		if err != nil {
 			ext.Error.Set(serverSpan, true)
 			serverSpan.LogFields(traceLog.String("event", "error"), traceLog.String("message", err.Error()))
 		}
+		setRPCTags(serverSpan, msg, false, err)
+		setResultTags(serverSpan, req, rsp, err)
 		serverSpan.Finish()
 
 		return rsp, err
@@ -162,26 +314,29 @@ func ServerFilter(z *zipkinPlugin) filter.ServerFilter {
 // ClientFilter returns a distributed tracing filter for RPC client
 func ClientFilter(z *zipkinPlugin) filter.ClientFilter {
 	return func(ctx context.Context, req, rsp interface{}, handler filter.ClientHandleFunc) error {
+		if untraced(ctx) {
+			return handler(ctx, req, rsp)
+		}
 
 		var parentSpanCtx opentracing.SpanContext
 		if parent := opentracing.SpanFromContext(ctx); parent != nil {
 			parentSpanCtx = parent.Context()
 		}
 
+		msg := codec.Message(ctx)
 		opts := []opentracing.StartSpanOption{
 			opentracing.ChildOf(parentSpanCtx),
 			ext.SpanKindRPCClient,
 		}
-		msg := codec.Message(ctx)
-
-		tracer := z.tracers[msg.CalleeServiceName()]
-		if tracer == nil {
-			tracer = opentracing.GlobalTracer()
+		if callee := msg.CalleeServiceName(); callee != "" {
+			opts = append(opts, opentracing.Tag{Key: string(ext.PeerService), Value: callee})
 		}
 
-		clientSpan := tracer.StartSpan(msg.ClientRPCName(), opts...)
+		tracer := z.clientTracer(ctx, msg)
+		op := samplingOperation{service: msg.CallerServiceName(), name: msg.ClientRPCName()}
+		clientSpan := z.sampleErrors(tracer, startSpan(tracer, op, opts...), op.name, opts...)
 
-		var carrier interface{}
+		var carrier textMap
 		var md codec.MetaData
 		switch msg.ClientReqHead().(type) {
 		case *trpcHTTP.ClientReqHeader:
@@ -189,7 +344,7 @@ func ClientFilter(z *zipkinPlugin) filter.ClientFilter {
 			if header.Header == nil {
 				header.Header = http.Header{}
 			}
-			carrier = opentracing.HTTPHeadersCarrier(header.Header)
+			carrier = httpHeaderTextMap(header.Header)
 		default:
 			md = msg.ClientMetaData().Clone()
 			if md == nil {
@@ -197,23 +352,100 @@ func ClientFilter(z *zipkinPlugin) filter.ClientFilter {
 			}
 			carrier = metadataTextMap(md)
 		}
-		log.Debugf("carrier: %+v", carrier)
-		if err := tracer.Inject(clientSpan.Context(), opentracing.HTTPHeaders, carrier); err != nil {
-			log.Errorf("trpc-opentracing-zipkin: failed to serialize trace information: %v", err)
+		if sc, ok := clientSpan.Context().(zipkinOpentracing.SpanContext); ok {
+			tc := traceContextFromContext(ctx).child(model.SpanContext(sc))
+			z.baggage.restrict(tc)
+			z.baggage.tag(clientSpan, tc)
+			z.propagation().inject(tc, carrier)
 		}
+		log.Debugf("carrier: %+v", carrier)
 		if md != nil {
 			msg.WithClientMetaData(md)
 		}
 		ctx = opentracing.ContextWithSpan(ctx, clientSpan)
 
-		log.Debugf("span: %+v", clientSpan.Context().(zipkin.SpanContext))
+		log.Debugf("span: %+v", clientSpan.Context())
 		err := handler(ctx, req, rsp)
 		if err != nil {
 			ext.Error.Set(clientSpan, true)
 			clientSpan.LogFields(traceLog.String("event", "error"), traceLog.String("message", err.Error()))
 		}
+		setRPCTags(clientSpan, msg, true, err)
+		setResultTags(clientSpan, req, rsp, err)
+		if reported(clientSpan, err) {
+			// the remote address is resolved by the selector during the call
+			setRemoteEndpoint(clientSpan, peerEndpoint(msg.CalleeServiceName(), msg.RemoteAddr()))
+		}
 		clientSpan.Finish()
 
 		return err
 	}
 }
+
+// extract extracts the trace context of the incoming request msg from carrier,
+// unless the service does not trust it. The sampling decision of the upstream is kept as the parent policy allows,
+// and requests forcing sampling get the debug flag even without trace context.
+func (z *zipkinPlugin) extract(carrier textMap, msg codec.Msg) (*traceContext, bool) {
+	tc, found, errs := z.propagation().extract(carrier)
+	for _, e := range errs {
+		log.Errorf("trpc-opentracing-zipkin: failed to parse trace information: %v", e)
+	}
+	if trust := z.incomingContext(msg.CalleeServiceName()); trust == IgnoreContext || trust == LinkContext {
+		tc, found = untrusted(tc, found, trust == LinkContext)
+	}
+	if found && !z.parentPolicy(msg.CalleeServiceName()).respects(msg) {
+		log.Debugf("trpc-opentracing-zipkin: sampling decision of %s ignored", msg.CallerServiceName())
+		ignoreDecision(tc)
+	}
+	if z.forceSampler.forced(carrier) {
+		log.Debugf("trpc-opentracing-zipkin: sampling forced by %s", z.forceSampler.key)
+		force(tc)
+		found = true
+	}
+	return tc, found
+}
+
+// parentPolicy returns the parent policy of service.
+func (z *zipkinPlugin) parentPolicy(service string) *parentPolicy {
+	if p, ok := z.parentPolicies[service]; ok {
+		return p
+	}
+	return z.parentPolicies[""]
+}
+
+// incomingContext returns how the incoming trace context of service is used.
+func (z *zipkinPlugin) incomingContext(service string) string {
+	if trust, ok := z.incomingContexts[service]; ok {
+		return trust
+	}
+	return z.incomingContexts[""]
+}
+
+// serverOperation describes the server call of msg to samplers.
+func serverOperation(msg codec.Msg) samplingOperation {
+	return samplingOperation{
+		service: msg.CalleeServiceName(),
+		name:    msg.ServerRPCName(),
+		caller:  msg.CallerServiceName(),
+	}
+}
+
+// propagation returns the configured propagators, B3 is used if the plugin is not set up.
+func (z *zipkinPlugin) propagation() propagators {
+	if z.propagators == nil {
+		return propagators{b3Propagator{}}
+	}
+	return z.propagators
+}
+
+// clientTracer returns the tracer of the calling service, which is looked up by
+// the caller service name or taken from the server span in ctx.
+func (z *zipkinPlugin) clientTracer(ctx context.Context, msg codec.Msg) opentracing.Tracer {
+	if tracer := z.tracers[msg.CallerServiceName()]; tracer != nil {
+		return tracer
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return span.Tracer()
+	}
+	return opentracing.GlobalTracer()
+}
@@ -16,12 +16,17 @@ package zipkin
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"testing"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 	trpc "trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/codec"
 	trpcHTTP "trpc.group/trpc-go/trpc-go/http"
 )
 
@@ -131,6 +136,105 @@ func Test_metadataTextMap_ForeachKey(t *testing.T) {
 	}
 }
 
+func TestZipkinPlugin_SetupSharesReporter(t *testing.T) {
+	old := trpc.GlobalConfig()
+	defer trpc.SetGlobalConfig(old)
+	global := &trpc.Config{}
+	global.Server.Service = []*trpc.ServiceConfig{
+		{Name: "trpc.app.server.Service1", IP: "127.0.0.1", Port: 8001},
+		{Name: "trpc.app.server.Service2", IP: "127.0.0.1", Port: 8002},
+	}
+	trpc.SetGlobalConfig(global)
+
+	z := &zipkinPlugin{}
+	cfg := trpc.Config{}
+	err := yaml.Unmarshal([]byte(conf1), &cfg)
+	assert.Nil(t, err)
+	zipkinCfg := cfg.Plugins["tracing"]["zipkin"]
+	assert.Nil(t, z.Setup("", &zipkinCfg))
+	assert.Len(t, z.tracers, 2)
+	// the global tracer and the service tracers share one reporter
+	assert.Len(t, z.reporters, 1)
+}
+
+func TestZipkinPlugin_SetupServiceOverrides(t *testing.T) {
+	old := trpc.GlobalConfig()
+	defer trpc.SetGlobalConfig(old)
+	global := &trpc.Config{}
+	global.Server.Service = []*trpc.ServiceConfig{
+		{Name: "trpc.app.server.Public", IP: "127.0.0.1", Port: 8001},
+		{Name: "trpc.app.server.Admin", IP: "127.0.0.1", Port: 8002},
+		{Name: "trpc.app.server.Health", IP: "127.0.0.1", Port: 8003},
+	}
+	trpc.SetGlobalConfig(global)
+
+	z := &zipkinPlugin{}
+	cfg := trpc.Config{}
+	err := yaml.Unmarshal([]byte(`
+plugins:
+ tracing:
+   zipkin:
+     reporter:
+       type: noop
+     sampler:
+       type: always
+     services:
+       trpc.app.server.Public:
+         sampler:
+           type: counting
+           counting:
+             rate: 0.01
+       trpc.app.server.Admin:
+         reporter:
+           type: http
+           http:
+             url: http://localhost:9412/api/v2/spans
+       trpc.app.server.Health:
+         enabled: false
+`), &cfg)
+	assert.Nil(t, err)
+	zipkinCfg := cfg.Plugins["tracing"]["zipkin"]
+	assert.Nil(t, z.Setup("", &zipkinCfg))
+	defer z.Close()
+	// global and public tracers share the top-level reporter, the admin
+	// tracer has its own
+	assert.Len(t, z.reporters, 2)
+	assert.Equal(t, opentracing.NoopTracer{}, z.tracers["trpc.app.server.Health"])
+}
+
+func TestZipkinPlugin_SetupInvalidConfig(t *testing.T) {
+	z := &zipkinPlugin{}
+	cfg := trpc.Config{}
+	err := yaml.Unmarshal([]byte(`
+plugins:
+ tracing:
+   zipkin:
+     reporter:
+       type: kafka
+     sampler:
+       type: sometimes
+`), &cfg)
+	assert.Nil(t, err)
+	zipkinCfg := cfg.Plugins["tracing"]["zipkin"]
+	err = z.Setup("", &zipkinCfg)
+	assert.EqualError(t, err, "trpc-opentracing-zipkin: invalid config: "+
+		"param [sampler.type] invalid: unknown sampler type \"sometimes\"; param [reporter.kafka] invalid: missing")
+}
+
+func TestZipkinPlugin_Close(t *testing.T) {
+	assert.Nil(t, (&zipkinPlugin{}).Close())
+
+	z := &zipkinPlugin{}
+	cfg := trpc.Config{}
+	err := yaml.Unmarshal([]byte(conf1), &cfg)
+	assert.Nil(t, err)
+	zipkinCfg := cfg.Plugins["tracing"]["zipkin"]
+	assert.Nil(t, z.Setup("", &zipkinCfg))
+	assert.Equal(t, defaultShutdownTimeout, z.shutdownTimeout)
+	assert.Nil(t, z.Close())
+	assert.True(t, z.reporters[0].closed)
+}
+
 func TestClientFilter(t *testing.T) {
 	z := &zipkinPlugin{}
 	cfg := trpc.Config{}
@@ -158,6 +262,34 @@ func TestClientFilter(t *testing.T) {
 	})
 }
 
+func TestClientFilter_CallerTracer(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.caller.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	z := &zipkinPlugin{tracers: map[string]opentracing.Tracer{"trpc.app.caller.Service": tracer}}
+
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCallerServiceName("trpc.app.caller.Service")
+	msg.WithCalleeServiceName("trpc.app.callee.Service")
+	msg.WithClientRPCName("/trpc.app.callee.Service/Hello")
+	handler := func(ctx context.Context, req interface{}, rsp interface{}) error {
+		msg.WithRemoteAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 8000})
+		return nil
+	}
+	assert.Nil(t, ClientFilter(z)(ctx, nil, nil, handler))
+
+	spans := rec.Flush()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, model.Client, spans[0].Kind)
+	assert.Equal(t, "trpc.app.caller.Service", spans[0].LocalEndpoint.ServiceName)
+	assert.Equal(t, &model.Endpoint{
+		ServiceName: "trpc.app.callee.Service",
+		IPv4:        net.ParseIP("10.0.0.2").To4(),
+		Port:        8000,
+	}, spans[0].RemoteEndpoint)
+}
+
 func TestServerFilter(t *testing.T) {
 	z := &zipkinPlugin{}
 	cfg := trpc.Config{}
@@ -195,3 +327,103 @@ func TestServerFilter(t *testing.T) {
 		assert.NotEqual(t, err, nil)
 	})
 }
+
+func TestFilter_W3CPropagation(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: NeverSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	ps, err := newPropagators([]string{W3CPropagation, B3Propagation}, "")
+	assert.Nil(t, err)
+	z := &zipkinPlugin{
+		tracers:     map[string]opentracing.Tracer{"trpc.app.server.Service": tracer},
+		propagators: ps,
+	}
+
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCalleeServiceName("trpc.app.server.Service")
+	msg.WithServerMetaData(codec.MetaData{
+		"traceparent": []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		"tracestate":  []byte("congo=t61rcWkgMzE"),
+	})
+	var clientMD codec.MetaData
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+		clientMsg.WithCallerServiceName("trpc.app.server.Service")
+		err := ClientFilter(z)(clientCtx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+			clientMD = codec.Message(ctx).ClientMetaData()
+			return nil
+		})
+		return nil, err
+	}
+	_, err = ServerFilter(z)(ctx, nil, handler)
+	assert.Nil(t, err)
+
+	// sampled upstream although the local sampler never samples
+	spans := rec.Flush()
+	assert.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String())
+	}
+	assert.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$", string(clientMD["traceparent"]))
+	assert.Equal(t, "congo=t61rcWkgMzE", string(clientMD["tracestate"]))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", string(clientMD["x-b3-traceid"]))
+}
+
+func TestFilter_StaleTraceContext(t *testing.T) {
+	rec := recorder.NewReporter()
+	c := &Config{ServiceName: "trpc.app.server.Service", Sampler: &SamplerConfig{Type: AlwaysSampler}}
+	tracer, err := c.newOpenTracingTracer(rec)
+	assert.Nil(t, err)
+	ps, err := newPropagators([]string{B3Propagation, W3CPropagation}, "")
+	assert.Nil(t, err)
+	z := &zipkinPlugin{
+		tracers:     map[string]opentracing.Tracer{"trpc.app.server.Service": tracer},
+		propagators: ps,
+	}
+
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCalleeServiceName("trpc.app.server.Service")
+	msg.WithServerMetaData(codec.MetaData{
+		"b3":            []byte("80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"),
+		"traceparent":   []byte("00-80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-01"),
+		"tracestate":    []byte("congo=t61rcWkgMzE"),
+		"uber-trace-id": []byte("80f198ee56343ba864fe8b2a57d3eff7:e457b5a2e4d86bd1:0:1"),
+		"uberctx-user":  []byte("alice"),
+		"app-key":       []byte("kept"),
+	})
+	var clientMD codec.MetaData
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		// trpc forwards the server metadata to the downstream calls
+		clientCtx, clientMsg := codec.WithCloneMessage(ctx)
+		clientMsg.WithCallerServiceName("trpc.app.server.Service")
+		err := ClientFilter(z)(clientCtx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+			clientMD = codec.Message(ctx).ClientMetaData()
+			return nil
+		})
+		return nil, err
+	}
+	_, err = ServerFilter(z)(ctx, nil, handler)
+	assert.Nil(t, err)
+
+	spans := rec.Flush()
+	assert.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, model.Client, client.Kind)
+	assert.Equal(t, server.TraceID, client.TraceID)
+	assert.Equal(t, server.ID, *client.ParentID)
+	assert.NotContains(t, clientMD, "b3")
+	assert.NotContains(t, clientMD, "tracestate")
+	assert.Equal(t, fmt.Sprintf("00-%s-%s-01", client.TraceID, client.ID), string(clientMD["traceparent"]))
+	// formats which are not configured are left alone
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7:e457b5a2e4d86bd1:0:1", string(clientMD["uber-trace-id"]))
+	assert.Equal(t, "alice", string(clientMD["uberctx-user"]))
+	assert.Equal(t, "kept", string(clientMD["app-key"]))
+	assert.Equal(t, client.ID.String(), string(clientMD["x-b3-spanid"]))
+
+	// the downstream takes the client span as parent
+	tc, found, errs := ps.extract(metadataTextMap(clientMD))
+	assert.True(t, found)
+	assert.Empty(t, errs)
+	assert.Equal(t, client.ID, tc.spanContext.ID)
+}